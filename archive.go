@@ -0,0 +1,454 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/satran/goimp/vcs"
+)
+
+var cmdArchive = &Command{
+	UsageLine: "archive [-o] [-file] [-p]",
+	Short:     "exports the dependencies to a tarball",
+	Long: `exports the dependencies to a tarball
+
+archive writes the files of every repository of the Godeps file, at
+its pinned revision, to a tarball along with a manifest giving the
+revision and the SHA-256 checksum of the content of each repository.
+Version control metadata and files that are not committed are left
+out, and the working trees are not touched.
+
+The tarball is reproducible: entries are sorted and their times,
+owners and permissions are fixed, so the same pins always give the
+same bytes. Use 'goimp unarchive' to restore it.
+
+-o	path of the tarball, compressed with gzip unless it ends in
+	.tar, defaults to vendor.tar.gz
+-file	file to read pins from, defaults to Godeps
+-p	specify the directory of the package, by default it is "."
+`,
+}
+
+var cmdUnarchive = &Command{
+	UsageLine: "unarchive [-d] [-f] archive",
+	Short:     "restores the dependencies of a tarball",
+	Long: `restores the dependencies of a tarball
+
+unarchive extracts the repositories of a tarball made by 'goimp
+archive' and checks them against its manifest. Nothing is put in
+place unless every repository matches its checksum.
+
+-d	directory receiving the import paths, defaults to $GOPATH/src.
+	Give the vendor directory of the project to vendor them
+-f	replaces the repositories already present in the directory,
+	which are refused otherwise
+`,
+}
+
+func init() {
+	cmdArchive.Run = runArchive // break init loop
+	addProjectFlags(&cmdArchive.Flag, "p", "file")
+	cmdUnarchive.Run = runUnarchive
+}
+
+var (
+	archiveOutput  = cmdArchive.Flag.String("o", "vendor.tar.gz", "path of the tarball")
+	unarchiveDir   = cmdUnarchive.Flag.String("d", "", "directory receiving the import paths")
+	unarchiveForce = cmdUnarchive.Flag.Bool("f", false, "replace existing repositories")
+)
+
+// manifestName is the name of the manifest, the first entry of an
+// archive.
+const manifestName = "goimp.manifest"
+
+// archiveEntry is a repository of an archive.
+type archiveEntry struct {
+	Repository string
+	Revision   string
+	Sum        string
+
+	repo  *vcs.VCS
+	files []archiveFile
+}
+
+// archiveFile is a file of a repository, named relative to its root.
+// The content of a regular file is Data, or else the Size bytes at
+// Offset of the spool file the repositories are exported to.
+type archiveFile struct {
+	Name   string
+	Mode   int64
+	Link   string
+	Data   []byte
+	Offset int64
+	Size   int64
+}
+
+func runArchive(cmd *Command, args []string) {
+	spool, err := ioutil.TempFile("", "goimp-archive")
+	if err != nil {
+		elog.Fatal(err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	entries, err := archiveEntries(getImportsFromFile(project.DepsFile()), spool)
+	if err != nil {
+		elog.Fatal(err)
+	}
+	f, err := os.Create(*archiveOutput)
+	if err != nil {
+		elog.Fatal(err)
+	}
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if !strings.HasSuffix(*archiveOutput, ".tar") {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	err = writeArchive(w, entries, spool)
+	if gz != nil && err == nil {
+		err = gz.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*archiveOutput)
+		elog.Fatal(err)
+	}
+}
+
+// archiveEntries returns the repositories of imports, sorted by
+// repository, exporting their content at the pinned revision to spool
+// and computing its checksum on the way.
+func archiveEntries(imports []Import, spool io.WriteSeeker) ([]*archiveEntry, error) {
+	byRoot := make(map[string]*archiveEntry)
+	var entries []*archiveEntry
+	for _, imp := range imports {
+		if imp.Hash == "" {
+			return nil, fmt.Errorf("%s: no pinned revision", imp.Package)
+		}
+		v, err := vcs.New(importDir(imp), goPathSrcs...)
+		if err != nil {
+			return nil, err
+		}
+		root := pkgPath(v.Root)
+		if e, ok := byRoot[root]; ok {
+			if e.Revision != imp.Hash {
+				return nil, fmt.Errorf("conflicting revisions for repository %s: %s and %s",
+					root, e.Revision, imp.Hash)
+			}
+			continue
+		}
+		e := &archiveEntry{Repository: root, Revision: imp.Hash, repo: v}
+		byRoot[root] = e
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Repository < entries[j].Repository
+	})
+	for _, e := range entries {
+		var err error
+		if e.files, e.Sum, err = exportFiles(e.repo, e.Revision, spool); err != nil {
+			return nil, fmt.Errorf("%s: %s", e.Repository, err)
+		}
+	}
+	return entries, nil
+}
+
+// exportFiles copies the content of the regular files of the
+// repository v at rev to spool as it is exported, unless spool is nil.
+// It returns the regular files and symbolic links, sorted by name, and
+// their checksum: the SHA-256 of the sorted "checksum  name" lines of
+// the files, the checksum of a symbolic link being that of its target
+// path.
+func exportFiles(v *vcs.VCS, rev string, spool io.WriteSeeker) ([]archiveFile, string, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(v.Export(rev, pw))
+	}()
+	defer pr.Close()
+
+	var files []archiveFile
+	var lines []string
+	tr := tar.NewReader(pr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		f := archiveFile{Name: path.Clean(hdr.Name), Mode: 0644}
+		h := sha256.New()
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if hdr.Mode&0111 != 0 {
+				f.Mode = 0755
+			}
+			var w io.Writer = h
+			if spool != nil {
+				if f.Offset, err = spool.Seek(0, io.SeekCurrent); err != nil {
+					return nil, "", err
+				}
+				w = io.MultiWriter(spool, h)
+			}
+			if f.Size, err = io.Copy(w, tr); err != nil {
+				return nil, "", err
+			}
+		case tar.TypeSymlink:
+			f.Mode = 0777
+			f.Link = hdr.Linkname
+			io.WriteString(h, f.Link)
+		default:
+			continue
+		}
+		files = append(files, f)
+		lines = append(lines, fmt.Sprintf("%x  %s\n", h.Sum(nil), f.Name))
+	}
+	// wait for the export to end, reporting its failure
+	if _, err := io.Copy(ioutil.Discard, pr); err != nil {
+		return nil, "", err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files, sumLines(lines), nil
+}
+
+// writeArchive writes the manifest of entries followed by their files,
+// read from spool, to w as a tar archive.
+func writeArchive(w io.Writer, entries []*archiveEntry, spool io.ReaderAt) error {
+	tw := tar.NewWriter(w)
+	var manifest bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&manifest, "%s\t%s\t%s\n", e.Repository, e.Revision, e.Sum)
+	}
+	if err := writeArchiveFile(tw, archiveFile{Name: manifestName, Mode: 0644, Data: manifest.Bytes()}, nil); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		for _, f := range e.files {
+			f.Name = e.Repository + "/" + f.Name
+			if err := writeArchiveFile(tw, f, spool); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// writeArchiveFile writes f to tw with a fixed time and owner, its
+// content being read from spool unless it has Data.
+func writeArchiveFile(tw *tar.Writer, f archiveFile, spool io.ReaderAt) error {
+	hdr := &tar.Header{
+		Name:     f.Name,
+		Mode:     f.Mode,
+		ModTime:  time.Unix(0, 0),
+		Typeflag: tar.TypeReg,
+		Size:     int64(len(f.Data)),
+	}
+	var data io.Reader = bytes.NewReader(f.Data)
+	if f.Data == nil && spool != nil {
+		hdr.Size = f.Size
+		data = io.NewSectionReader(spool, f.Offset, f.Size)
+	}
+	if f.Link != "" {
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = f.Link
+		hdr.Size = 0
+		return tw.WriteHeader(hdr)
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, data)
+	return err
+}
+
+func runUnarchive(cmd *Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+	}
+	dir := *unarchiveDir
+	if dir == "" {
+		dir = installSrc()
+	}
+	entries, err := unarchive(args[0], dir, *unarchiveForce)
+	if err != nil {
+		elog.Fatal(err)
+	}
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, ' ', 0)
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\n", e.Repository, e.Revision)
+	}
+	w.Flush()
+}
+
+// unarchive extracts the archive at file into a staging directory
+// inside dir, checks every repository against the manifest and only
+// then moves them in place.
+func unarchive(file, dir string, force bool) ([]*archiveEntry, error) {
+	tr, closeTar, err := openTar(file)
+	if err != nil {
+		return nil, err
+	}
+	defer closeTar()
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != manifestName {
+		return nil, fmt.Errorf("%s: no manifest, not made by goimp archive", file)
+	}
+	entries, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		target := filepath.Join(dir, filepath.FromSlash(e.Repository))
+		if exists(target) && !force {
+			return nil, fmt.Errorf("%s exists, use -f to replace it", target)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	staging, err := ioutil.TempDir(dir, ".goimp-unarchive")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	for _, e := range entries {
+		if err := os.MkdirAll(filepath.Join(staging, filepath.FromSlash(e.Repository)), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	lines := make(map[string][]string)
+	links := newSet()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := hdr.Name
+		if path.IsAbs(name) || path.Clean(name) != name || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("%s: invalid path %q", file, name)
+		}
+		for p := path.Dir(name); p != "."; p = path.Dir(p) {
+			if links.Contains(p) {
+				return nil, fmt.Errorf("%s: %q is below a symbolic link", file, name)
+			}
+		}
+		repo := manifestRepository(entries, name)
+		if repo == "" {
+			return nil, fmt.Errorf("%s: %q is not in the manifest", file, name)
+		}
+		target := filepath.Join(staging, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(hdr.Mode).Perm())
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(io.MultiWriter(f, h), tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return nil, err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return nil, err
+			}
+			io.WriteString(h, hdr.Linkname)
+			links.Add(name)
+		default:
+			return nil, fmt.Errorf("%s: unexpected entry %q", file, name)
+		}
+		rel := name[len(repo)+1:]
+		lines[repo] = append(lines[repo], fmt.Sprintf("%x  %s\n", h.Sum(nil), rel))
+	}
+
+	for _, e := range entries {
+		if sum := sumLines(lines[e.Repository]); sum != e.Sum {
+			return nil, fmt.Errorf("%s: checksum mismatch for %s, the archive is corrupt", file, e.Repository)
+		}
+	}
+	for _, e := range entries {
+		if nestedRepository(entries, e.Repository) {
+			// moved along with the repository holding it
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(e.Repository))
+		if err := os.RemoveAll(target); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(filepath.Join(staging, filepath.FromSlash(e.Repository)), target); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// readManifest parses the "repository revision checksum" lines of a
+// manifest.
+func readManifest(r io.Reader) ([]*archiveEntry, error) {
+	var entries []*archiveEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid manifest line %q", scanner.Text())
+		}
+		entries = append(entries, &archiveEntry{Repository: fields[0], Revision: fields[1], Sum: fields[2]})
+	}
+	return entries, scanner.Err()
+}
+
+// manifestRepository returns the repository of entries holding the
+// archive entry name, the longest one when they are nested.
+func manifestRepository(entries []*archiveEntry, name string) string {
+	repo := ""
+	for _, e := range entries {
+		if strings.HasPrefix(name, e.Repository+"/") && len(e.Repository) > len(repo) {
+			repo = e.Repository
+		}
+	}
+	return repo
+}
+
+// nestedRepository reports whether repo is inside another repository
+// of entries.
+func nestedRepository(entries []*archiveEntry, repo string) bool {
+	for _, e := range entries {
+		if strings.HasPrefix(repo, e.Repository+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestManifestRepository(t *testing.T) {
+	entries := []*archiveEntry{
+		{Repository: "github.com/a/lib"},
+		{Repository: "github.com/a/lib/nested"},
+	}
+	for name, want := range map[string]string{
+		"github.com/a/lib/lib.go":      "github.com/a/lib",
+		"github.com/a/lib/nested/n.go": "github.com/a/lib/nested",
+		"github.com/a/library/x.go":    "",
+		"goimp.manifest":               "",
+	} {
+		if got := manifestRepository(entries, name); got != want {
+			t.Errorf("manifestRepository(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestUnarchiveNested(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goimp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var entries []*archiveEntry
+	for repo, data := range map[string]string{
+		"github.com/a/lib":        "package lib\n",
+		"github.com/a/lib/nested": "package nested\n",
+	} {
+		line := fmt.Sprintf("%x  x.go\n", sha256.Sum256([]byte(data)))
+		entries = append(entries, &archiveEntry{
+			Repository: repo,
+			Revision:   "abc",
+			Sum:        sumLines([]string{line}),
+			files:      []archiveFile{{Name: "x.go", Mode: 0644, Data: []byte(data)}},
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Repository < entries[j].Repository
+	})
+	file := filepath.Join(dir, "vendor.tar")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeArchive(f, entries, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	src := filepath.Join(dir, "src")
+	for _, force := range []bool{false, true} {
+		if _, err := unarchive(file, src, force); err != nil {
+			t.Fatalf("unarchive with force %v: %s", force, err)
+		}
+		for _, repo := range []string{"github.com/a/lib", "github.com/a/lib/nested"} {
+			if _, err := os.Stat(filepath.Join(src, filepath.FromSlash(repo), "x.go")); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+}
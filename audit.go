@@ -0,0 +1,500 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/satran/goimp/vcs"
+)
+
+var cmdAudit = &Command{
+	UsageLine: "audit -db path [-format] [-allow-unchecked] [-file] [-p]",
+	Short:     "checks dependencies against known vulnerabilities",
+	Long: `checks dependencies against known vulnerabilities
+
+audit matches every entry of the Godeps file against an advisory
+database in the OSV format, read from a directory of JSON files or a
+.zip, .tar or .tar.gz archive of them. No network access is needed.
+
+An entry is affected when its repository is named by an advisory and
+its pinned revision falls in an affected range. Ranges of commits are
+matched through the history of the repository, and ranges of versions
+through the tags the revision descends from.
+
+-db	path of the advisory database
+-format	output format, text or json, defaults to text
+-allow-unchecked	exit zero when entries could not be checked
+-p	specify the directory of the package, by default it is "."
+-file	file to read pins from, defaults to Godeps
+
+Entries that cannot be checked, such as those whose repository or
+pinned revision is missing, are reported as unchecked. The exit status
+is non-zero when an affected entry is found, or when an entry is
+unchecked unless -allow-unchecked is given.
+`,
+}
+
+func init() {
+	cmdAudit.Run = runAudit // break init loop
+	addProjectFlags(&cmdAudit.Flag, "p", "file")
+}
+
+var (
+	auditDB     = cmdAudit.Flag.String("db", "", "path of the advisory database")
+	auditFormat = cmdAudit.Flag.String("format", "text", "output format, text or json")
+	auditAllow  = cmdAudit.Flag.Bool("allow-unchecked", false, "exit zero when entries could not be checked")
+)
+
+// osvEntry is the part of an OSV advisory used by audit.
+type osvEntry struct {
+	ID       string   `json:"id"`
+	Aliases  []string `json:"aliases"`
+	Summary  string   `json:"summary"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges   []osvRange `json:"ranges"`
+		Versions []string   `json:"versions"`
+	} `json:"affected"`
+}
+
+type osvRange struct {
+	Type   string `json:"type"`
+	Events []struct {
+		Introduced   string `json:"introduced"`
+		Fixed        string `json:"fixed"`
+		LastAffected string `json:"last_affected"`
+	} `json:"events"`
+}
+
+// finding is an entry of the deps file affected by an advisory.
+type finding struct {
+	Package  string   `json:"package"`
+	Revision string   `json:"revision"`
+	Advisory string   `json:"advisory"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Summary  string   `json:"summary,omitempty"`
+	Fixed    []string `json:"fixed,omitempty"`
+}
+
+// uncheckedEntry is an entry of the deps file that could not be
+// matched against the advisories.
+type uncheckedEntry struct {
+	Package  string `json:"package"`
+	Revision string `json:"revision"`
+	Error    string `json:"error"`
+}
+
+func runAudit(cmd *Command, args []string) {
+	if *auditDB == "" {
+		cmd.Usage()
+	}
+	advisories, err := loadAdvisories(*auditDB)
+	if err != nil {
+		elog.Fatalf("error loading advisories: %s", err)
+	}
+
+	findings, unchecked := auditImports(getImportsFromFile(project.DepsFile()), advisories)
+	switch *auditFormat {
+	case "json":
+		if findings == nil {
+			findings = []finding{}
+		}
+		if unchecked == nil {
+			unchecked = []uncheckedEntry{}
+		}
+		err = writeJSON(os.Stdout, struct {
+			Findings  []finding        `json:"findings"`
+			Unchecked []uncheckedEntry `json:"unchecked"`
+		}{findings, unchecked})
+	case "text":
+		w := new(tabwriter.Writer)
+		w.Init(os.Stdout, 0, 8, 1, ' ', 0)
+		for _, f := range findings {
+			fixed := strings.Join(f.Fixed, ", ")
+			if fixed == "" {
+				fixed = "no fix"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\tfixed in %s\t%s\n",
+				f.Package, f.Revision, f.Advisory, fixed, f.Summary)
+		}
+		for _, u := range unchecked {
+			fmt.Fprintf(w, "%s\t%s\tunchecked\t%s\n", u.Package, orDash(u.Revision), u.Error)
+		}
+		err = w.Flush()
+	default:
+		elog.Fatalf("unknown format %q", *auditFormat)
+	}
+	if err != nil {
+		elog.Fatal(err)
+	}
+	if len(findings) > 0 || len(unchecked) > 0 && !*auditAllow {
+		os.Exit(1)
+	}
+}
+
+// auditImports returns the advisories affecting imports, and the
+// imports that could not be checked.
+func auditImports(imports []Import, advisories map[string][]*osvEntry) ([]finding, []uncheckedEntry) {
+	var findings []finding
+	var unchecked []uncheckedEntry
+	for _, imp := range imports {
+		fs, err := auditImport(imp, advisories)
+		if err != nil {
+			unchecked = append(unchecked, uncheckedEntry{imp.Package, imp.Hash, err.Error()})
+		}
+		findings = append(findings, fs...)
+	}
+	return findings, unchecked
+}
+
+// loadAdvisories reads the OSV entries at path, a directory or an
+// archive, indexing them by affected package name.
+func loadAdvisories(path string) (map[string][]*osvEntry, error) {
+	index := make(map[string][]*osvEntry)
+	add := func(name string, r io.Reader) error {
+		if !strings.HasSuffix(name, ".json") {
+			return nil
+		}
+		var e osvEntry
+		if err := json.NewDecoder(r).Decode(&e); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		seen := newSet()
+		for _, a := range e.Affected {
+			if !seen.Contains(a.Package.Name) {
+				seen.Add(a.Package.Name)
+				index[a.Package.Name] = append(index[a.Package.Name], &e)
+			}
+		}
+		return nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case fi.IsDir():
+		err = filepath.Walk(path, func(name string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() {
+				return err
+			}
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return add(name, f)
+		})
+	case strings.HasSuffix(path, ".zip"):
+		err = readZip(path, add)
+	default:
+		err = readTar(path, add)
+	}
+	return index, err
+}
+
+func readZip(path string, fn func(string, io.Reader) error) error {
+	z, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(f.Name, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readTar calls fn on the regular files of the tar archive at path,
+// which may be compressed with gzip.
+func readTar(path string, fn func(string, io.Reader) error) error {
+	tr, closeTar, err := openTar(path)
+	if err != nil {
+		return err
+	}
+	defer closeTar()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// openTar opens the tar archive at path, compressed with gzip when
+// its name ends in .gz or .tgz. The returned function closes it.
+func openTar(path string) (*tar.Reader, func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var r io.Reader = f
+	closeTar := func() { f.Close() }
+	if strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		r = gz
+		closeTar = func() {
+			gz.Close()
+			f.Close()
+		}
+	}
+	return tar.NewReader(r), closeTar, nil
+}
+
+// auditImport returns the advisories affecting the pinned revision
+// of imp.
+func auditImport(imp Import, advisories map[string][]*osvEntry) ([]finding, error) {
+	v, err := vcs.New(importDir(imp), goPathSrcs...)
+	if err != nil {
+		return nil, err
+	}
+	root := pkgPath(v.Root)
+	rev := imp.Hash
+	if rev == "" {
+		if rev, err = v.CommitHash(); err != nil {
+			return nil, err
+		}
+	} else if !v.HasRevision(rev) {
+		return nil, fmt.Errorf("revision %s not found in %s", rev, v.Root)
+	}
+
+	var findings []finding
+	seen := newSet()
+	for name, entries := range advisories {
+		if name != root && !strings.HasPrefix(name, root+"/") &&
+			!strings.HasPrefix(root, name+"/") {
+			continue
+		}
+		for _, e := range entries {
+			if seen.Contains(e.ID) {
+				continue
+			}
+			affected, fixed, err := affects(v, rev, name, e)
+			if err != nil {
+				return findings, fmt.Errorf("%s: %s", e.ID, err)
+			}
+			if affected {
+				seen.Add(e.ID)
+				findings = append(findings, finding{
+					Package:  imp.Package,
+					Revision: rev,
+					Advisory: e.ID,
+					Aliases:  e.Aliases,
+					Summary:  e.Summary,
+					Fixed:    fixed,
+				})
+			}
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Advisory < findings[j].Advisory
+	})
+	return findings, nil
+}
+
+// affects reports whether rev of the repository v is affected by the
+// entries of e for the package name, along with the fixed versions.
+func affects(v *vcs.VCS, rev, name string, e *osvEntry) (bool, []string, error) {
+	var version string
+	if tag, err := v.NearestTag(rev); err == nil {
+		version = tag
+	}
+	affected := false
+	var fixed []string
+	for _, a := range e.Affected {
+		if a.Package.Name != name {
+			continue
+		}
+		for _, ver := range a.Versions {
+			if version != "" && semverCompare(version, ver) == 0 {
+				affected = true
+			}
+		}
+		for _, r := range a.Ranges {
+			var in bool
+			var err error
+			switch r.Type {
+			case "GIT":
+				in, err = inRange(r, func(event string) (int, error) {
+					if strings.HasPrefix(rev, event) || strings.HasPrefix(event, rev) {
+						return 0, nil
+					}
+					if !v.HasRevision(event) {
+						// unknown to the repository, so not an ancestor
+						return -1, nil
+					}
+					ok, err := v.IsAncestor(event, rev)
+					if ok {
+						return 1, err
+					}
+					return -1, err
+				})
+			case "SEMVER", "ECOSYSTEM":
+				if version == "" {
+					continue
+				}
+				in, err = inRange(r, func(event string) (int, error) {
+					return semverCompare(version, event), nil
+				})
+			default:
+				continue
+			}
+			if err != nil {
+				return false, nil, err
+			}
+			affected = affected || in
+			for _, ev := range r.Events {
+				if ev.Fixed != "" {
+					fixed = append(fixed, ev.Fixed)
+				}
+			}
+		}
+	}
+	return affected, fixed, nil
+}
+
+// inRange reports whether a revision is in the range r. cmp tells
+// where an event lies relative to the revision: 1 when the revision
+// descends from it, 0 when it is the revision and -1 otherwise. The
+// events are applied in order.
+func inRange(r osvRange, cmp func(string) (int, error)) (bool, error) {
+	in := false
+	for _, ev := range r.Events {
+		switch {
+		case ev.Introduced == "0":
+			in = true
+		case ev.Introduced != "":
+			c, err := cmp(ev.Introduced)
+			if err != nil {
+				return false, err
+			}
+			if c >= 0 {
+				in = true
+			}
+		case ev.Fixed != "":
+			c, err := cmp(ev.Fixed)
+			if err != nil {
+				return false, err
+			}
+			if c >= 0 {
+				in = false
+			}
+		case ev.LastAffected != "":
+			c, err := cmp(ev.LastAffected)
+			if err != nil {
+				return false, err
+			}
+			if c > 0 {
+				in = false
+			}
+		}
+	}
+	return in, nil
+}
+
+// semverCompare compares the semantic versions a and b, with or
+// without a leading v, returning -1, 0 or 1.
+func semverCompare(a, b string) int {
+	pa, pb := semverParts(a), semverParts(b)
+	for i := 0; i < 3; i++ {
+		if pa.nums[i] != pb.nums[i] {
+			if pa.nums[i] < pb.nums[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case pa.pre == pb.pre:
+		return 0
+	case pa.pre == "":
+		return 1
+	case pb.pre == "":
+		return -1
+	}
+	ea, eb := strings.Split(pa.pre, "."), strings.Split(pb.pre, ".")
+	for i := 0; i < len(ea) && i < len(eb); i++ {
+		if ea[i] == eb[i] {
+			continue
+		}
+		na, erra := strconv.Atoi(ea[i])
+		nb, errb := strconv.Atoi(eb[i])
+		switch {
+		case erra == nil && errb == nil:
+			if na < nb {
+				return -1
+			}
+			return 1
+		case erra == nil:
+			return -1
+		case errb == nil:
+			return 1
+		case ea[i] < eb[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	switch {
+	case len(ea) < len(eb):
+		return -1
+	case len(ea) > len(eb):
+		return 1
+	}
+	return 0
+}
+
+type semver struct {
+	nums [3]int
+	pre  string
+}
+
+func semverParts(s string) semver {
+	var v semver
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		s, v.pre = s[:i], s[i+1:]
+	}
+	for i, part := range strings.SplitN(s, ".", 3) {
+		v.nums[i], _ = strconv.Atoi(part)
+	}
+	return v
+}
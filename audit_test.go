@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSemverCompare(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.3", "1.2.3", 0},
+		{"v1.2.3", "v1.10.0", -1},
+		{"v2.0.0", "v1.9.9", 1},
+		{"v1.0.0-rc.1", "v1.0.0", -1},
+		{"v1.0.0-rc.2", "v1.0.0-rc.10", -1},
+		{"v1.0.0-beta", "v1.0.0-alpha", 1},
+	} {
+		if got := semverCompare(c.a, c.b); got != c.want {
+			t.Errorf("semverCompare(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestInRange(t *testing.T) {
+	var r osvRange
+	err := json.Unmarshal([]byte(`{"type": "SEMVER", "events": [
+		{"introduced": "1.0.0"}, {"fixed": "1.2.0"},
+		{"introduced": "2.0.0"}, {"fixed": "2.0.3"}]}`), &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for version, want := range map[string]bool{
+		"v0.9.0": false,
+		"v1.0.0": true,
+		"v1.1.5": true,
+		"v1.2.0": false,
+		"v1.9.0": false,
+		"v2.0.2": true,
+		"v2.1.0": false,
+	} {
+		got, _ := inRange(r, func(event string) (int, error) {
+			return semverCompare(version, event), nil
+		})
+		if got != want {
+			t.Errorf("%s in range = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestAuditUnchecked(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "goimp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(src string, srcs []string) { goPathSrc, goPathSrcs = src, srcs }(goPathSrc, goPathSrcs)
+	goPathSrc = filepath.Join(dir, "src")
+	goPathSrcs = []string{goPathSrc}
+	repo := filepath.Join(goPathSrc, "example.com", "lib")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var rev string
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"commit", "-q", "--allow-empty", "-m", "init"},
+		{"rev-parse", "HEAD"},
+	} {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+		}
+		rev = strings.TrimSpace(string(out))
+	}
+
+	bad := strings.Repeat("1", 40)
+	_, unchecked := auditImports([]Import{
+		{Package: "example.com/lib", Hash: rev},
+		{Package: "example.com/lib/sub", Hash: bad},
+		{Package: "example.com/gone", Hash: rev},
+	}, nil)
+	var got []string
+	for _, u := range unchecked {
+		got = append(got, u.Package+" "+u.Revision)
+	}
+	want := []string{"example.com/lib/sub " + bad, "example.com/gone " + rev}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("unchecked %v, want %v", got, want)
+	}
+}
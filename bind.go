@@ -1,18 +1,51 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"reflect"
+	"sort"
 	"time"
+
+	"github.com/satran/goimp/deps"
+	"github.com/satran/goimp/vcs"
 )
 
 var cmdBind = &Command{
-	UsageLine: "bind [-file] [-p]",
+	UsageLine: "bind [-file] [-p] [-tags] [-poll] [-debounce] [-on-get] [-on-write]",
 	Short:     "binds Godeps file to imports",
 	Long: `watches for Changes in the Godeps file or in each packges, updating either when detected
 
+When a dependency is changed both in the Godeps file and in the
+workspace, or its repository has uncommitted changes or is checked out
+at a revision that was never pinned, it is left untouched and reported
+as a conflict to be resolved by hand, as is a dependency whose
+checkout fails. Until the Godeps file and the workspace agree on its
+revision again, its pin is neither checked out nor overwritten.
+
+The go files of the package, the Godeps file and the checked out
+revision of each dependency are watched using inotify on Linux and
+polling elsewhere.
+
 -p	specify the directory of the package, by default it is "."
+	or the packages of the project configuration
 -file	file to get commits from, defaults to Godeps
+-tags	comma separated build tags used to select the files parsed
+-poll	poll for changes at the given interval instead of using
+	file system notifications
+-debounce	wait for changes to settle for this long before
+	updating, defaults to 300ms
+-on-get	shell command run after dependencies are checked out,
+	defaults to hooks.on-get of the project configuration
+-on-write	shell command run after the Godeps file is written,
+	defaults to hooks.on-write of the project configuration
+
+Hooks run in the directory of the project configuration, or in the
+package directory when there is none, with GOIMP_EVENT set to get or
+write, GOIMP_PACKAGES to the changed packages separated by spaces and
+GOIMP_CHANGES to one "package old new" line per changed package. A
+failing hook is reported and bind keeps watching.
 `,
 }
 
@@ -20,13 +53,15 @@ type Bind struct{}
 
 func init() {
 	cmdBind.Run = runBind // break init loop
+	addProjectFlags(&cmdBind.Flag, "p", "file", "tags")
 }
 
 var (
-	// Won't this conflict with other flags?
-	getBindDir       = cmdBind.Flag.String("p", ".", "path of the go package")
-	getBindFile      = cmdBind.Flag.String("file", "Godeps", "file to get to")
 	getBindVerbosity = cmdBind.Flag.Bool("v", false, "set verbosity")
+	bindPoll         = cmdBind.Flag.Duration("poll", 0, "polling interval")
+	bindDebounce     = cmdBind.Flag.Duration("debounce", 300*time.Millisecond, "quiet period before updating")
+	bindOnGet        = cmdBind.Flag.String("on-get", "", "command run after getting dependencies")
+	bindOnWrite      = cmdBind.Flag.String("on-write", "", "command run after writing the Godeps file")
 )
 
 func notify(arg string) {
@@ -35,14 +70,49 @@ func notify(arg string) {
 	}
 }
 
-func runBindGet() {
+// runBindGet checks out imports and returns those that succeeded,
+// and a conflict for each that failed, whose pin must be kept.
+func runBindGet(imports []Import) ([]Import, []bindConflict) {
 	notify("Updating dependencies...")
-	runGet(&Command{}, []string{})
+	opts := syncOptions()
+	opts.KeepGoing = true
+	report, _ := deps.Sync(context.Background(), imports, opts)
+	var ok []Import
+	var failed []bindConflict
+	for _, res := range report.Results {
+		if res.Failed() {
+			failed = append(failed, bindConflict{Package: res.Package,
+				Reason: "checkout failed: " + res.Err.Error(), File: res.Hash})
+			continue
+		}
+		if res.Err != nil {
+			continue
+		}
+		ok = append(ok, res.Import)
+	}
+	return ok, failed
 }
 
-func runBindWrite() {
+// runBindWrite writes the deps file and returns the written imports,
+// keeping the pins of keep.
+func runBindWrite(keep map[string]string) (map[string]string, error) {
 	notify("Updating Godeps file...")
-	runWrite(&Command{}, []string{})
+	imports, err := writeDeps(project.DepsFile(), true, true, keep)
+	if err != nil {
+		return nil, err
+	}
+	return importsToMap(imports), nil
+}
+
+// keepPins replaces the revision of the imports found in keep with
+// the one it holds.
+func keepPins(imports []Import, keep map[string]string) []Import {
+	for i, imp := range imports {
+		if hash, ok := keep[imp.Package]; ok {
+			imports[i].Hash = hash
+		}
+	}
+	return imports
 }
 
 func importsToMap(arg []Import) map[string]string {
@@ -53,45 +123,213 @@ func importsToMap(arg []Import) map[string]string {
 	return ret
 }
 
-func runBind(cmd *Command, args []string) {
-	// Update dependencies automatically
-	runBindGet()
-
-	var readImports map[string]string
-	checkReadImports := func() bool {
-		newImports := importsToMap(getImportsFromFile(*getBindDir, *getBindFile))
-		if !reflect.DeepEqual(readImports, newImports) {
-			readImports = newImports
-			return true
+// bindConflict is a dependency bind refuses to update.
+type bindConflict struct {
+	Package   string
+	Reason    string
+	Base      string
+	File      string
+	Workspace string
+}
+
+func (c bindConflict) String() string {
+	return fmt.Sprintf("%s: %s\n\tbase:      %s\n\tGodeps:    %s\n\tworkspace: %s",
+		c.Package, c.Reason, orDash(c.Base), orDash(c.File), orDash(c.Workspace))
+}
+
+// planBindGet compares the deps file and the workspace with their
+// state at the last sync, fileBase and wsBase. It returns the imports
+// changed in the file that can be checked out, and the conflicts for
+// those whose workspace diverged too.
+func planBindGet(fileBase, wsBase, file, ws map[string]string) ([]Import, []bindConflict) {
+	var imports []Import
+	var conflicts []bindConflict
+	for pkg, hash := range file {
+		if hash == fileBase[pkg] {
+			continue
+		}
+		current, ok := ws[pkg]
+		if current == hash {
+			continue
+		}
+		c := bindConflict{pkg, "", fileBase[pkg], hash, current}
+		switch {
+		case ok && current != wsBase[pkg]:
+			c.Reason = "changed in both the deps file and the workspace"
+			conflicts = append(conflicts, c)
+		case ok && current != fileBase[pkg]:
+			c.Reason = "workspace is at a revision that is not pinned"
+			conflicts = append(conflicts, c)
+		default:
+			imports = append(imports, Import{Package: pkg, Hash: hash})
 		}
-		return false
 	}
+	sort.Sort(Imports(imports))
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Package < conflicts[j].Package
+	})
+	return imports, conflicts
+}
 
-	var writeImports map[string]string
-	checkWriteImports := func() bool {
-		newImports := importsToMap(list(".", true, true))
-		if !reflect.DeepEqual(writeImports, newImports) {
-			return true
+// checkDirty splits imports into those whose repository can be
+// checked out and conflicts for those with uncommitted changes.
+func checkDirty(imports []Import, fileBase, ws map[string]string) ([]Import, []bindConflict) {
+	var clean []Import
+	var conflicts []bindConflict
+	for _, imp := range imports {
+		v, err := vcs.New(importDir(imp), goPathSrcs...)
+		if err != nil {
+			clean = append(clean, imp)
+			continue
+		}
+		dirty, err := v.Dirty()
+		if err != nil || !dirty {
+			clean = append(clean, imp)
+			continue
 		}
-		return false
+		conflicts = append(conflicts, bindConflict{imp.Package,
+			"repository has uncommitted changes",
+			fileBase[imp.Package], imp.Hash, ws[imp.Package]})
 	}
+	return clean, conflicts
+}
 
+// holdConflicts moves the imports of the packages of unresolved to
+// the conflicts, as they must not be checked out.
+func holdConflicts(imports []Import, unresolved map[string]bindConflict) ([]Import, []bindConflict) {
+	var held []Import
+	var conflicts []bindConflict
+	for _, imp := range imports {
+		c, ok := unresolved[imp.Package]
+		if !ok {
+			held = append(held, imp)
+			continue
+		}
+		c.File = imp.Hash
+		conflicts = append(conflicts, c)
+	}
+	return held, conflicts
+}
+
+// resolveConflicts removes from unresolved the packages whose deps
+// file entry and workspace revision agree again, and returns them.
+func resolveConflicts(unresolved map[string]bindConflict, file, ws map[string]string) []string {
+	var resolved []string
+	for pkg := range unresolved {
+		if file[pkg] == ws[pkg] {
+			delete(unresolved, pkg)
+			resolved = append(resolved, pkg)
+		}
+	}
+	sort.Strings(resolved)
+	return resolved
+}
+
+// filePins returns the deps file revisions of the packages of
+// unresolved, which writing must not overwrite.
+func filePins(unresolved map[string]bindConflict, file map[string]string) map[string]string {
+	keep := make(map[string]string)
+	for pkg := range unresolved {
+		if hash, ok := file[pkg]; ok {
+			keep[pkg] = hash
+		}
+	}
+	return keep
+}
+
+func runBind(cmd *Command, args []string) {
+	// Conflicts are remembered until resolved by hand: until then
+	// their packages are neither checked out nor written.
+	unresolved := make(map[string]bindConflict)
+
+	// Update dependencies automatically
+	_, failed := runBindGet(getImportsFromFile(project.DepsFile()))
+	for _, c := range failed {
+		unresolved[c.Package] = c
+		log.Printf("conflict: %s", c)
+	}
 	for {
-		readImports = importsToMap(getImportsFromFile(*getBindDir, *getBindFile))
-		writeImports = importsToMap(list(".", true, true))
+		fileBase := importsToMap(getImportsFromFile(project.DepsFile()))
+		imports := list(project.Packages, true, true)
+		wsBase := importsToMap(imports)
 
+		w := newBindWatcher()
+		if err := watchProject(w, imports); err != nil {
+			log.Fatal(err)
+		}
 		for {
-			time.Sleep(time.Second)
-			if checkReadImports() {
-				runBindGet()
-				runBindWrite()
+			waitChanges(w, *bindDebounce)
+			file := importsToMap(getImportsFromFile(project.DepsFile()))
+			ws := importsToMap(list(project.Packages, true, true))
+			for _, pkg := range resolveConflicts(unresolved, file, ws) {
+				log.Printf("conflict resolved: %s", pkg)
+			}
+			if reflect.DeepEqual(file, fileBase) {
+				if reflect.DeepEqual(ws, wsBase) {
+					continue
+				}
+				if written, err := runBindWrite(filePins(unresolved, file)); err != nil {
+					log.Print(err)
+				} else {
+					runHook(bindHook(*bindOnWrite, project.Hooks.OnWrite), "write", diffImports(file, written))
+				}
 				break
 			}
-			if checkWriteImports() {
-				runBindWrite()
+
+			imports, conflicts := planBindGet(fileBase, wsBase, file, ws)
+			imports, held := holdConflicts(imports, unresolved)
+			conflicts = append(conflicts, held...)
+			imports, dirty := checkDirty(imports, fileBase, ws)
+			conflicts = append(conflicts, dirty...)
+			if len(imports) > 0 {
+				got, failed := runBindGet(imports)
+				for _, c := range failed {
+					c.Base, c.Workspace = fileBase[c.Package], ws[c.Package]
+					conflicts = append(conflicts, c)
+				}
+				var changes []importChange
+				for _, imp := range got {
+					changes = append(changes, importChange{imp.Package, ws[imp.Package], imp.Hash})
+				}
+				runHook(bindHook(*bindOnGet, project.Hooks.OnGet), "get", changes)
+			}
+			if len(conflicts) > 0 {
+				// Writing now would overwrite the conflicting
+				// pins, wait for the developer to resolve them.
+				for _, c := range conflicts {
+					unresolved[c.Package] = c
+					log.Printf("conflict: %s", c)
+				}
 				break
 			}
+			if written, err := runBindWrite(filePins(unresolved, file)); err != nil {
+				log.Print(err)
+			} else {
+				runHook(bindHook(*bindOnWrite, project.Hooks.OnWrite), "write", diffImports(file, written))
+			}
+			break
 		}
+		w.Close()
 		notify("Complete")
 	}
 }
+
+// bindHook returns the hook set by flag, or else by the configuration.
+func bindHook(flag, config string) string {
+	if flag != "" {
+		return flag
+	}
+	return config
+}
+
+// newBindWatcher creates the watcher selected by the bind flags.
+func newBindWatcher() watcher {
+	if *bindPoll > 0 {
+		return newPollWatcher(*bindPoll)
+	}
+	w, err := newWatcher(time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return w
+}
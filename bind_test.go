@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestPlanBindGet(t *testing.T) {
+	base := map[string]string{"a": "1", "b": "1", "c": "1", "d": "1"}
+	file := map[string]string{"a": "2", "b": "2", "c": "2", "d": "1", "e": "1"}
+	ws := map[string]string{"a": "1", "b": "3", "c": "2", "d": "4"}
+	wsBase := map[string]string{"a": "1", "b": "1", "c": "1", "d": "4"}
+
+	imports, conflicts := planBindGet(base, wsBase, file, ws)
+	if len(imports) != 2 || imports[0] != (Import{Package: "a", Hash: "2"}) || imports[1] != (Import{Package: "e", Hash: "1"}) {
+		t.Fatalf("unexpected imports to get: %v", imports)
+	}
+	if len(conflicts) != 1 || conflicts[0].Package != "b" {
+		t.Fatalf("expected a conflict for b, got %v", conflicts)
+	}
+}
+
+func TestUnresolvedConflicts(t *testing.T) {
+	unresolved := map[string]bindConflict{
+		"a": {Package: "a", Reason: "changed in both the deps file and the workspace"},
+		"b": {Package: "b", Reason: "repository has uncommitted changes"},
+	}
+	file := map[string]string{"a": "2", "b": "2", "c": "1"}
+	ws := map[string]string{"a": "3", "b": "2", "c": "4"}
+
+	if resolved := resolveConflicts(unresolved, file, ws); len(resolved) != 1 || resolved[0] != "b" {
+		t.Fatalf("expected b to be resolved, got %v", resolved)
+	}
+	imports, held := holdConflicts([]Import{{Package: "a", Hash: "5"}, {Package: "c", Hash: "1"}}, unresolved)
+	if len(imports) != 1 || imports[0].Package != "c" || len(held) != 1 || held[0].File != "5" {
+		t.Fatalf("unexpected imports %v and held conflicts %v", imports, held)
+	}
+	written := keepPins([]Import{{Package: "a", Hash: "3"}, {Package: "c", Hash: "4"}}, filePins(unresolved, file))
+	if written[0].Hash != "2" || written[1].Hash != "4" {
+		t.Fatalf("the pin of a was overwritten: %v", written)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/satran/goimp/deps"
+	"github.com/satran/goimp/vcs"
+)
+
+// configFiles are the names of the project configuration files,
+// looked up in the working directory and its parents.
+var configFiles = []string{".goimp.toml", ".goimp.json"}
+
+// Config holds the project settings shared by every command. It is
+// read from the configuration file and overridden by the flags.
+type Config struct {
+	// File is the name of the deps file, relative to Root.
+	File string `json:"file"`
+	// Packages are the directories of the packages of the project.
+	Packages []string `json:"packages"`
+	// Import is the import path of Root. By default it is found from
+	// go.mod, the import comment of the package or GOPATH.
+	Import string `json:"import"`
+	// Ignore are the patterns of import paths neither listed nor
+	// followed, see Ignored.
+	Ignore []string `json:"ignore"`
+	// Replace maps import paths of repositories to the URL of a fork
+	// or a local directory used by get instead.
+	Replace map[string]string `json:"replace"`
+	// Tags are the build tags used to select the files parsed.
+	Tags []string `json:"tags"`
+	// Jobs is the number of repositories processed concurrently.
+	Jobs int `json:"jobs"`
+	// Cache is the directory where goimp keeps its data.
+	Cache string `json:"cache"`
+	// Auth maps host names to the settings used to reach their
+	// private repositories.
+	Auth map[string]vcs.HostAuth `json:"auth"`
+	// Netrc is the netrc file holding credentials.
+	Netrc string `json:"netrc"`
+	// Licenses is the license policy of the licenses command.
+	Licenses struct {
+		Allow []string `json:"allow"`
+		Deny  []string `json:"deny"`
+	} `json:"licenses"`
+	// Hooks are the commands run by bind.
+	Hooks struct {
+		OnGet   string `json:"on-get"`
+		OnWrite string `json:"on-write"`
+	} `json:"hooks"`
+
+	// Root is the directory of the configuration file, or the
+	// package directory when there is none.
+	Root string `json:"-"`
+	// Path is the path of the configuration file, if any.
+	Path string `json:"-"`
+}
+
+// project is the configuration of the current run.
+var project = defaultConfig()
+
+func defaultConfig() *Config {
+	c := &Config{
+		File:     "Godeps",
+		Packages: []string{"."},
+		Jobs:     4,
+		Root:     ".",
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		c.Cache = filepath.Join(dir, "goimp")
+	}
+	return c
+}
+
+// projectRoot returns the absolute path of the project root, used where
+// a relative one is ambiguous.
+func projectRoot() string {
+	root, err := filepath.Abs(project.Root)
+	if err != nil {
+		elog.Fatal(err)
+	}
+	return root
+}
+
+// DepsFile returns the path of the deps file.
+func (c *Config) DepsFile() string {
+	return filepath.Join(c.Root, c.File)
+}
+
+// StateDir returns the directory holding the local state of goimp
+// for the project, such as links and snapshots.
+func (c *Config) StateDir() string {
+	return filepath.Join(c.Root, ".goimp")
+}
+
+// Ignored reports whether the import path pkg matches an ignore
+// pattern. A pattern matches the import path itself and the packages
+// below it, and may contain the wildcards of path.Match, or end in
+// "/..." to only match the packages below it.
+func (c *Config) Ignored(pkg string) bool {
+	for _, pattern := range c.Ignore {
+		if strings.HasSuffix(pattern, "/...") {
+			pattern = strings.TrimSuffix(pattern, "/...")
+			if strings.HasPrefix(pkg, pattern+"/") {
+				return true
+			}
+			continue
+		}
+		for p := pkg; ; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			if !strings.Contains(p, "/") {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// Replacement returns the replace rule for the import path pkg. The
+// rule with the longest matching path wins.
+func (c *Config) Replacement(pkg string) (deps.Replacement, bool) {
+	var r deps.Replacement
+	for from, to := range c.Replace {
+		if pkg != from && !strings.HasPrefix(pkg, from+"/") {
+			continue
+		}
+		if len(from) > len(r.Path) {
+			r = deps.Replacement{Path: from, Target: to, Local: isLocalPath(to)}
+		}
+	}
+	if r.Local && !filepath.IsAbs(r.Target) {
+		r.Target = filepath.Join(c.Root, r.Target)
+	}
+	return r, r.Path != ""
+}
+
+// isLocalPath reports whether target of a replace rule is a local
+// directory rather than a repository URL.
+func isLocalPath(target string) bool {
+	return filepath.IsAbs(target) || strings.HasPrefix(target, "./") ||
+		strings.HasPrefix(target, "../") || target == "." || target == ".."
+}
+
+// Dir returns the directory of the main package.
+func (c *Config) Dir() string {
+	return c.Packages[0]
+}
+
+// Flags shared by the commands working on a project.
+var (
+	flagDir  string
+	flagFile string
+	flagTags string
+)
+
+// addProjectFlags registers on fs the shared project flags named by
+// names, among "p", "file" and "tags": those the command honours.
+func addProjectFlags(fs *flag.FlagSet, names ...string) {
+	for _, name := range names {
+		switch name {
+		case "p":
+			fs.StringVar(&flagDir, "p", ".", "path of the go package")
+		case "file":
+			fs.StringVar(&flagFile, "file", "Godeps", "name of the deps file")
+		case "tags":
+			fs.StringVar(&flagTags, "tags", "", "comma separated build tags")
+		default:
+			panic("unknown project flag " + name)
+		}
+	}
+}
+
+// loadProject sets project from the configuration file found from
+// the working directory and the flags explicitly set in fs.
+func loadProject(fs *flag.FlagSet) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	c, err := findConfig(cwd)
+	if err != nil {
+		return err
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if set["p"] {
+		c.Packages = []string{flagDir}
+		if c.Path == "" {
+			c.Root = flagDir
+		}
+	}
+	if set["file"] {
+		c.File = flagFile
+	}
+	if set["tags"] {
+		c.Tags = splitList(flagTags)
+	}
+	project = c
+	vcs.Default.Auth = c.Auth
+	vcs.Default.NetrcFile = c.Netrc
+	return nil
+}
+
+// findConfig reads the first configuration file found in dir or
+// its parents, returning the defaults when there is none.
+func findConfig(dir string) (*Config, error) {
+	for {
+		for _, name := range configFiles {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return readConfig(path)
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return defaultConfig(), nil
+		}
+		dir = parent
+	}
+}
+
+// readConfig reads the configuration file at path. Relative package
+// and cache directories are resolved against its directory.
+func readConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := defaultConfig()
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(content, c)
+	} else {
+		err = parseTOMLConfig(string(content), c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	c.Path = path
+	c.Root = filepath.Dir(path)
+	if len(c.Packages) == 0 {
+		c.Packages = []string{"."}
+	}
+	for i, dir := range c.Packages {
+		if !filepath.IsAbs(dir) {
+			c.Packages[i] = filepath.Join(c.Root, dir)
+		}
+	}
+	if c.Cache != "" && !filepath.IsAbs(c.Cache) {
+		c.Cache = filepath.Join(c.Root, c.Cache)
+	}
+	return c, nil
+}
+
+// parseTOMLConfig parses the subset of TOML used by the configuration
+// file: tables, and keys holding strings, integers, booleans or arrays
+// of strings on a single line.
+func parseTOMLConfig(content string, c *Config) error {
+	table := ""
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripTOMLComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return fmt.Errorf("line %d: expected key = value", n)
+		}
+		key := strings.Trim(strings.TrimSpace(line[:i]), `"`)
+		if table != "" {
+			key = table + "." + key
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return fmt.Errorf("line %d: %s", n, err)
+		}
+		if err := c.set(key, value); err != nil {
+			return fmt.Errorf("line %d: %s", n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// stripTOMLComment removes a trailing comment outside of strings.
+func stripTOMLComment(line string) string {
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == '#' && !quoted:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseTOMLValue parses a string, integer, boolean or array of strings.
+func parseTOMLValue(s string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated array %s", s)
+		}
+		var list []string
+		for _, elem := range strings.Split(s[1:len(s)-1], ",") {
+			elem = strings.TrimSpace(elem)
+			if elem == "" {
+				continue
+			}
+			v, err := parseTOMLValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			str, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("only arrays of strings are supported")
+			}
+			list = append(list, str)
+		}
+		return list, nil
+	case strings.HasPrefix(s, `"`), strings.HasPrefix(s, "'"):
+		if s[0] == '\'' {
+			if len(s) < 2 || !strings.HasSuffix(s, "'") {
+				return nil, fmt.Errorf("unterminated string %s", s)
+			}
+			return s[1 : len(s)-1], nil
+		}
+		return strconv.Unquote(s)
+	case s == "true", s == "false":
+		return s == "true", nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %s", s)
+	}
+	return n, nil
+}
+
+// setAuth assigns value to key, "host.setting", of the auth table.
+func (c *Config) setAuth(key string, value interface{}) error {
+	i := strings.LastIndex(key, ".")
+	if i < 0 {
+		return fmt.Errorf("unknown key %q", "auth."+key)
+	}
+	host, setting := strings.Trim(key[:i], `"`), key[i+1:]
+	if c.Auth == nil {
+		c.Auth = make(map[string]vcs.HostAuth)
+	}
+	a := c.Auth[host]
+	var ok bool
+	switch setting {
+	case "rewrite":
+		a.Rewrite, ok = value.(string)
+	case "helper":
+		a.Helper, ok = value.(string)
+	case "netrc":
+		a.Netrc, ok = value.(bool)
+	default:
+		return fmt.Errorf("unknown key %q", "auth."+key)
+	}
+	if !ok {
+		return fmt.Errorf("invalid value for %q", "auth."+key)
+	}
+	c.Auth[host] = a
+	return nil
+}
+
+// set assigns value to the configuration key.
+func (c *Config) set(key string, value interface{}) error {
+	var ok bool
+	switch key {
+	case "file":
+		c.File, ok = value.(string)
+	case "packages":
+		c.Packages, ok = value.([]string)
+	case "import":
+		c.Import, ok = value.(string)
+	case "ignore":
+		c.Ignore, ok = value.([]string)
+	case "tags":
+		c.Tags, ok = value.([]string)
+	case "jobs":
+		c.Jobs, ok = value.(int)
+	case "cache":
+		c.Cache, ok = value.(string)
+	case "hooks.on-get":
+		c.Hooks.OnGet, ok = value.(string)
+	case "hooks.on-write":
+		c.Hooks.OnWrite, ok = value.(string)
+	case "netrc":
+		c.Netrc, ok = value.(string)
+	case "licenses.allow":
+		c.Licenses.Allow, ok = value.([]string)
+	case "licenses.deny":
+		c.Licenses.Deny, ok = value.([]string)
+	default:
+		if strings.HasPrefix(key, "auth.") {
+			return c.setAuth(key[len("auth."):], value)
+		}
+		if strings.HasPrefix(key, "replace.") {
+			if c.Replace == nil {
+				c.Replace = make(map[string]string)
+			}
+			c.Replace[key[len("replace."):]], ok = value.(string)
+			break
+		}
+		return fmt.Errorf("unknown key %q", key)
+	}
+	if !ok {
+		return fmt.Errorf("invalid value for %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOMLConfig(t *testing.T) {
+	c := defaultConfig()
+	err := parseTOMLConfig(`
+# project settings
+file = "deps.txt"
+packages = [".", "cmd/tool"] # both binaries
+import = "example.com/tool"
+tags = ["integration"]
+jobs = 8
+
+[hooks]
+on-get = "go test ./..."
+`, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.File != "deps.txt" || c.Jobs != 8 || c.Hooks.OnGet != "go test ./..." ||
+		c.Import != "example.com/tool" {
+		t.Fatalf("unexpected config %+v", c)
+	}
+	if !reflect.DeepEqual(c.Packages, []string{".", "cmd/tool"}) {
+		t.Fatalf("unexpected packages %v", c.Packages)
+	}
+	if !reflect.DeepEqual(c.Tags, []string{"integration"}) {
+		t.Fatalf("unexpected tags %v", c.Tags)
+	}
+	if err := parseTOMLConfig(`unknown = 1`, c); err == nil {
+		t.Fatal("expected an error for an unknown key")
+	}
+}
+
+// TestReadmeConfig parses the sample configuration of the README, the
+// indented block following "### Configuration".
+func TestReadmeConfig(t *testing.T) {
+	content, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readme := string(content)
+	i := strings.Index(readme, "### Configuration")
+	if i < 0 {
+		t.Fatal("no configuration section in README.md")
+	}
+	var sample []string
+	for _, line := range strings.Split(readme[i:], "\n")[1:] {
+		if strings.HasPrefix(line, "    ") {
+			sample = append(sample, line[4:])
+		} else if line != "" && len(sample) > 0 {
+			break
+		}
+	}
+	c := defaultConfig()
+	if err := parseTOMLConfig(strings.Join(sample, "\n"), c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Netrc != "/etc/goimp/netrc" || !c.Auth["git.example.com"].Netrc ||
+		c.Hooks.OnWrite != "git diff Godeps" {
+		t.Fatalf("unexpected config %+v", c)
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	c := &Config{Ignore: []string{"example.com/internal", "example.com/*/gen", "example.com/mono/..."}}
+	for pkg, ignored := range map[string]bool{
+		"example.com/internal":     true,
+		"example.com/internal/foo": true,
+		"example.com/internals":    false,
+		"example.com/api/gen":      true,
+		"example.com/api/gen/v1":   true,
+		"example.com/api":          false,
+		"example.com/mono":         false,
+		"example.com/mono/lib":     true,
+	} {
+		if c.Ignored(pkg) != ignored {
+			t.Errorf("Ignored(%q) = %v, want %v", pkg, !ignored, ignored)
+		}
+	}
+}
+
+func TestReplacement(t *testing.T) {
+	c := &Config{Root: "/src/app", Replace: map[string]string{
+		"github.com/foo/bar":     "https://github.com/us/bar.git",
+		"github.com/foo/bar/sub": "../sub",
+	}}
+	r, ok := c.Replacement("github.com/foo/bar/pkg")
+	if !ok || r.Path != "github.com/foo/bar" || r.Local {
+		t.Fatalf("unexpected replacement %+v", r)
+	}
+	r, ok = c.Replacement("github.com/foo/bar/sub/...")
+	if !ok || r.Target != "/src/sub" || !r.Local {
+		t.Fatalf("unexpected replacement %+v", r)
+	}
+	if _, ok := c.Replacement("github.com/foo/baz"); ok {
+		t.Fatal("unexpected replacement for github.com/foo/baz")
+	}
+}
@@ -0,0 +1,205 @@
+// Package deps finds, records and fetches the dependencies of Go
+// packages. The dependencies are kept in a GOPATH, the packages
+// themselves may be anywhere.
+//
+// Scan walks the imports of packages, ReadFile and WriteFile handle
+// the deps file pinning each dependency to a revision, and Sync checks
+// those revisions out. Nothing is logged and no state is kept between
+// calls; the offline, retry and authentication settings of version
+// control commands are given to each call of Sync.
+package deps
+
+import (
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Import is a dependency pinned to a revision. Package is an import
+// path, or the root of a repository followed by "/..." to stand for
+// all of its packages. An empty Hash stands for the latest revision.
+type Import struct {
+	Package string
+	Hash    string
+}
+
+// Imports sorts imports by package.
+type Imports []Import
+
+func (i Imports) Len() int           { return len(i) }
+func (i Imports) Swap(m, n int)      { i[m], i[n] = i[n], i[m] }
+func (i Imports) Less(m, n int) bool { return i[m].Package < i[n].Package }
+
+// ReadFile returns the imports of the deps file at path, which holds
+// one "package hash" line per import.
+func ReadFile(path string) ([]Import, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ret []Import
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		imp := Import{Package: fields[0]}
+		if len(fields) > 1 {
+			imp.Hash = fields[1]
+		}
+		ret = append(ret, imp)
+	}
+	return ret, nil
+}
+
+// Write writes imports to w in the format of the deps file.
+func Write(w io.Writer, imports []Import) error {
+	tw := new(tabwriter.Writer)
+	tw.Init(w, 0, 8, 0, '\t', 0)
+	for _, imp := range imports {
+		fmt.Fprintf(tw, "%s\t%s\n", imp.Package, imp.Hash)
+	}
+	return tw.Flush()
+}
+
+// WriteFile replaces the content of the deps file at path with
+// imports. The content is written to a temporary file renamed over
+// path, which is left untouched on failure.
+func WriteFile(path string, imports []Import) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		// replace the file a symlink points to, not the symlink
+		path = target
+	}
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := Write(tmp, imports); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// srcRoots returns the src directories of the gopath entries.
+func srcRoots(gopath []string) []string {
+	roots := make([]string, len(gopath))
+	for i, entry := range gopath {
+		roots[i] = filepath.Join(entry, "src")
+	}
+	return roots
+}
+
+// FindDir returns the directory of the import path pkg in the first
+// entry of gopath holding it.
+func FindDir(gopath []string, pkg string) (string, bool) {
+	for _, src := range srcRoots(gopath) {
+		if dir := filepath.Join(src, filepath.FromSlash(pkg)); exists(dir) {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// ImportPath returns the import path of dir, relative to the entry of
+// gopath holding it.
+func ImportPath(gopath []string, dir string) (string, bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	for _, src := range srcRoots(gopath) {
+		if strings.HasPrefix(abs, src+string(filepath.Separator)) {
+			return filepath.ToSlash(abs[len(src)+1:]), true
+		}
+	}
+	return "", false
+}
+
+// FindImportPath returns the import path of the package in dir. It is
+// the module path of the closest go.mod file followed by the path of
+// dir below it, else the canonical import comment of the package, else
+// the path of dir relative to the entry of gopath holding it.
+func FindImportPath(dir string, gopath []string) (string, bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	if path, ok := modulePath(abs); ok {
+		return path, true
+	}
+	if pkg, err := build.ImportDir(abs, build.ImportComment); err == nil && pkg.ImportComment != "" {
+		return pkg.ImportComment, true
+	}
+	return ImportPath(gopath, abs)
+}
+
+// modulePath returns the import path of dir from the go.mod file of
+// dir or of its closest parent having one.
+func modulePath(dir string) (string, bool) {
+	for d := dir; ; {
+		content, err := ioutil.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			mod := parseModulePath(content)
+			if mod == "" {
+				return "", false
+			}
+			rel, err := filepath.Rel(d, dir)
+			if err != nil || rel == "." {
+				return mod, err == nil
+			}
+			return mod + "/" + filepath.ToSlash(rel), true
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return "", false
+		}
+		d = parent
+	}
+}
+
+// parseModulePath returns the path of the module directive of the
+// go.mod file content, or "" if there is none.
+func parseModulePath(content []byte) string {
+	for _, line := range strings.Split(string(content), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		if path, err := strconv.Unquote(fields[1]); err == nil {
+			return path
+		}
+		return fields[1]
+	}
+	return ""
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,48 @@
+package deps
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindImportPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeFiles(t, root, map[string]string{
+		"mod/go.mod":                    "// comment\nmodule \"example.com/mod\" // trailing\n\ngo 1.12\n",
+		"mod/sub/a.go":                  "package sub\n",
+		"comment/a.go":                  "package comment // import \"example.com/comment\"\n",
+		"gopath/src/example.com/x/a.go": "package x\n",
+		"unknown/a.go":                  "package unknown\n",
+	})
+	gopath := []string{filepath.Join(root, "gopath")}
+	for dir, want := range map[string]string{
+		"mod":                      "example.com/mod",
+		"mod/sub":                  "example.com/mod/sub",
+		"comment":                  "example.com/comment",
+		"gopath/src/example.com/x": "example.com/x",
+		"unknown":                  "",
+	} {
+		got, ok := FindImportPath(filepath.Join(root, filepath.FromSlash(dir)), gopath)
+		if got != want || ok != (want != "") {
+			t.Errorf("FindImportPath(%q) = %q, %v, want %q", dir, got, ok, want)
+		}
+	}
+}
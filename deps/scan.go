@@ -0,0 +1,303 @@
+package deps
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/satran/goimp/vcs"
+)
+
+// ScanOptions controls how packages are scanned.
+type ScanOptions struct {
+	// GOPATH are the entries imports are looked up in, in order.
+	GOPATH []string
+	// Tags are the build tags selecting the files parsed. Every file
+	// is parsed when there are none.
+	Tags []string
+	// Ignore reports whether an import path is left out, along with
+	// its own imports. It may be nil.
+	Ignore func(pkg string) bool
+	// Recursive follows the imports of the imported packages.
+	Recursive bool
+	// Hash resolves the revision checked out for each import, the
+	// packages of a repository being grouped as "root/...".
+	Hash bool
+	// Root is the directory of the project, which need not be in
+	// GOPATH, and RootPath its import path, found with FindImportPath
+	// when empty. Imports below RootPath are looked up in Root and,
+	// being part of the project, are followed but left out.
+	Root     string
+	RootPath string
+}
+
+// Graph is the result of a scan.
+type Graph struct {
+	// Packages are the import paths reached, sorted.
+	Packages []string
+	// Imports are the dependencies, sorted by package.
+	Imports []Import
+	// Missing are the import paths not found in GOPATH, sorted.
+	Missing []string
+	// Warnings are the problems that did not stop the scan, such as
+	// missing packages or files that could not be parsed.
+	Warnings []error
+}
+
+// NotFoundError is the warning of an import missing from GOPATH.
+type NotFoundError struct {
+	Package string
+	GOPATH  []string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("'%s' not found in %s", e.Package,
+		strings.Join(srcRoots(e.GOPATH), ", "))
+}
+
+// Scan returns the dependencies of the package in dir.
+func Scan(dir string, opts ScanOptions) (Graph, error) {
+	return ScanAll([]string{dir}, opts)
+}
+
+// ScanAll returns the dependencies of the packages in dirs, leaving
+// out the packages below dirs themselves and those of the project.
+func ScanAll(dirs []string, opts ScanOptions) (Graph, error) {
+	if opts.Recursive && len(opts.GOPATH) == 0 {
+		return Graph{}, errors.New("GOPATH must be set for recursive option")
+	}
+	s := &scanner{opts: opts, found: make(map[string]bool), missing: make(map[string]bool)}
+	if opts.Root != "" {
+		root, err := filepath.Abs(opts.Root)
+		if err != nil {
+			return Graph{}, err
+		}
+		s.opts.Root = root
+		if s.opts.RootPath == "" {
+			path, ok := FindImportPath(root, opts.GOPATH)
+			if !ok {
+				s.warnings = append(s.warnings, fmt.Errorf(
+					"cannot determine the import path of %s, its own packages are taken for dependencies", root))
+			}
+			s.opts.RootPath = path
+		}
+	}
+	for _, dir := range dirs {
+		if err := s.walk(dir); err != nil {
+			return Graph{}, err
+		}
+	}
+	var pkgs, missing []string
+	for pkg := range s.found {
+		pkgs = append(pkgs, pkg)
+	}
+	for pkg := range s.missing {
+		missing = append(missing, pkg)
+	}
+	for _, dir := range dirs {
+		if path, ok := s.importPath(dir); ok {
+			pkgs = purgeSubPackages(path, pkgs)
+		}
+	}
+	if s.opts.RootPath != "" {
+		pkgs = purgeSubPackages(s.opts.RootPath, pkgs)
+	}
+	sort.Strings(pkgs)
+	sort.Strings(missing)
+
+	g := Resolve(pkgs, opts)
+	g.Missing = missing
+	g.Warnings = append(s.warnings, g.Warnings...)
+	return g, nil
+}
+
+// Resolve returns the graph of the import paths pkgs, resolving their
+// revisions when opts.Hash is set. Packages missing from GOPATH are
+// then dropped.
+func Resolve(pkgs []string, opts ScanOptions) Graph {
+	g := Graph{Packages: pkgs}
+	roots := srcRoots(opts.GOPATH)
+	byRoot := make(map[string][]Import)
+	for _, pkg := range pkgs {
+		imp := Import{Package: pkg}
+		if !opts.Hash {
+			g.Imports = append(g.Imports, imp)
+			continue
+		}
+		dir, ok := FindDir(opts.GOPATH, pkg)
+		if !ok {
+			continue
+		}
+		v, err := vcs.New(dir, roots...)
+		if err != nil {
+			continue
+		}
+		hash, err := v.CommitHash()
+		if err != nil {
+			g.Warnings = append(g.Warnings, err)
+			continue
+		}
+		imp.Hash = hash
+		byRoot[v.Root] = append(byRoot[v.Root], imp)
+	}
+	for root, imps := range byRoot {
+		if len(imps) <= 1 {
+			g.Imports = append(g.Imports, imps...)
+			continue
+		}
+		path, _ := ImportPath(opts.GOPATH, root)
+		g.Imports = append(g.Imports, Import{Package: path + "/...", Hash: imps[0].Hash})
+	}
+	sort.Sort(Imports(g.Imports))
+	return g
+}
+
+// scanner holds the state of a scan.
+type scanner struct {
+	opts     ScanOptions
+	found    map[string]bool
+	missing  map[string]bool
+	warnings []error
+}
+
+// walk adds the imports reached from the package in dir.
+func (s *scanner) walk(dir string) error {
+	queue, err := s.dirImports(dir)
+	if err != nil {
+		return err
+	}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		if s.found[pkg] {
+			continue
+		}
+		s.found[pkg] = true
+		if !s.opts.Recursive && !s.own(pkg) {
+			continue
+		}
+		pkgDir, ok := s.findDir(pkg)
+		if !ok && s.own(pkg) {
+			// left to the compiler to report
+			continue
+		}
+		if !ok {
+			if !s.missing[pkg] {
+				s.missing[pkg] = true
+				s.warnings = append(s.warnings, &NotFoundError{pkg, s.opts.GOPATH})
+			}
+			continue
+		}
+		imports, err := s.dirImports(pkgDir)
+		if err != nil {
+			return err
+		}
+		queue = append(queue, imports...)
+	}
+	return nil
+}
+
+// own reports whether pkg is a package of the project.
+func (s *scanner) own(pkg string) bool {
+	path := s.opts.RootPath
+	return path != "" && (pkg == path || strings.HasPrefix(pkg, path+"/"))
+}
+
+// findDir returns the directory of pkg, in the project for its own
+// packages and in GOPATH otherwise.
+func (s *scanner) findDir(pkg string) (string, bool) {
+	if s.own(pkg) {
+		dir := filepath.Join(s.opts.Root, filepath.FromSlash(strings.TrimPrefix(pkg, s.opts.RootPath)))
+		return dir, exists(dir)
+	}
+	return FindDir(s.opts.GOPATH, pkg)
+}
+
+// importPath returns the import path of dir, in the project or in
+// GOPATH.
+func (s *scanner) importPath(dir string) (string, bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	if s.opts.RootPath != "" {
+		if abs == s.opts.Root {
+			return s.opts.RootPath, true
+		}
+		if strings.HasPrefix(abs, s.opts.Root+string(filepath.Separator)) {
+			return s.opts.RootPath + "/" + filepath.ToSlash(abs[len(s.opts.Root)+1:]), true
+		}
+	}
+	return ImportPath(s.opts.GOPATH, abs)
+}
+
+// dirImports returns the imports of the package in dir, leaving out
+// the standard library and the ignored paths so that they are not
+// followed either.
+func (s *scanner) dirImports(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ctx := build.Default
+	ctx.BuildTags = s.opts.Tags
+	fset := token.NewFileSet()
+	seen := make(map[string]bool)
+	var imports []string
+	parsed := false
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		if len(s.opts.Tags) > 0 {
+			if match, err := ctx.MatchFile(dir, name); err == nil && !match {
+				continue
+			}
+		}
+		if dir != "." {
+			name = filepath.Join(dir, name)
+		}
+		f, err := parser.ParseFile(fset, name, nil, parser.ImportsOnly)
+		if err != nil {
+			s.warnings = append(s.warnings, fmt.Errorf("ignoring unparsable file %q: %s", name, err))
+			continue
+		}
+		parsed = true
+		for _, imp := range f.Imports {
+			path, err := strconv.Unquote(imp.Path.Value)
+			if err != nil || seen[path] || isStdLib(path) {
+				continue
+			}
+			if s.opts.Ignore != nil && s.opts.Ignore(path) {
+				continue
+			}
+			seen[path] = true
+			imports = append(imports, path)
+		}
+	}
+	if !parsed {
+		return nil, nil
+	}
+	sort.Strings(imports)
+	return imports, nil
+}
+
+// purgeSubPackages removes pkg and the packages below it from imports.
+func purgeSubPackages(pkg string, imports []string) []string {
+	var ret []string
+	for _, imp := range imports {
+		if imp == pkg || strings.HasPrefix(imp, pkg+"/") {
+			continue
+		}
+		ret = append(ret, imp)
+	}
+	return ret
+}
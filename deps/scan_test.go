@@ -0,0 +1,45 @@
+package deps
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestScanOutsideGOPATH(t *testing.T) {
+	root, err := ioutil.TempDir("", "deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeFiles(t, root, map[string]string{
+		"app/go.mod":                      "module example.com/app\n",
+		"app/main.go":                     "package main\nimport (\n_ \"example.com/app/sub\"\n_ \"fmt\"\n)\n",
+		"app/sub/sub.go":                  "package sub\nimport _ \"example.com/lib\"\n",
+		"gopath/src/example.com/lib/a.go": "package lib\nimport _ \"example.com/other\"\n",
+	})
+	app := filepath.Join(root, "app")
+	opts := ScanOptions{GOPATH: []string{filepath.Join(root, "gopath")}, Root: app}
+
+	g, err := Scan(app, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"example.com/lib"}; !reflect.DeepEqual(g.Packages, want) {
+		t.Errorf("direct packages = %v, want %v", g.Packages, want)
+	}
+
+	opts.Recursive = true
+	g, err = Scan(app, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"example.com/lib", "example.com/other"}; !reflect.DeepEqual(g.Packages, want) {
+		t.Errorf("packages = %v, want %v", g.Packages, want)
+	}
+	if want := []string{"example.com/other"}; !reflect.DeepEqual(g.Missing, want) {
+		t.Errorf("missing = %v, want %v", g.Missing, want)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package deps
 
 import (
 	"strings"
@@ -7,7 +7,7 @@ import (
 var isStdLib func(path string) bool
 
 func init() {
-	builtins := newSet()
+	builtins := make(map[string]bool)
 	for _, path := range []string{
 		"C", "archive", "bufio", "builtin", "bytes", "compress", "container",
 		"crypto", "database", "debug", "encoding", "errors", "expvar",
@@ -15,10 +15,10 @@ func init() {
 		"log", "math", "mime", "net", "os", "path", "reflect", "regexp",
 		"runtime", "sort", "strconv", "strings", "sync", "syscall",
 		"testing", "text", "time", "unicode", "unsafe"} {
-		builtins.Add(path)
+		builtins[path] = true
 	}
 	isStdLib = func(path string) bool {
 		p := strings.SplitN(path, "/", 2)[0]
-		return builtins.Contains(p)
+		return builtins[p]
 	}
 }
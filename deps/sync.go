@@ -0,0 +1,535 @@
+package deps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satran/goimp/vcs"
+)
+
+// SyncOptions controls how Sync processes imports.
+type SyncOptions struct {
+	// GOPATH are the entries repositories are looked up in, in order.
+	GOPATH []string
+	// Install is the entry of GOPATH new repositories are downloaded
+	// into, defaults to the first one.
+	Install string
+	// Reset checks out the latest code instead of the pinned hash.
+	Reset bool
+	// Force checks out repositories with local work.
+	Force bool
+	// Stash sets uncommitted changes aside before checking out.
+	Stash bool
+	// VCS holds the offline, retry and authentication settings of
+	// the version control commands and of go get. With VCS.Offline
+	// set only the repositories and revisions already present in
+	// GOPATH are used. A nil VCS stands for vcs.NewConfig().
+	VCS *vcs.Config
+	// KeepGoing continues with the remaining imports after a failure.
+	KeepGoing bool
+	// Jobs is the maximum number of repositories processed
+	// concurrently.
+	Jobs int
+	// Timeout limits each repository operation, zero means no limit.
+	Timeout time.Duration
+	// Replace returns the replacement of the repository of an import
+	// path. It may be nil.
+	Replace func(pkg string) (Replacement, bool)
+	// Linked returns the local directory an import path is linked to,
+	// such imports being left untouched. It may be nil.
+	Linked func(pkg string) (string, bool)
+	// Progress is called at the start of each phase with the number
+	// of repositories it processes. It may be nil.
+	Progress func(phase string, total int) Progress
+	// Stdout and Stderr receive the output of go get, which is
+	// discarded when they are nil.
+	Stdout, Stderr io.Writer
+}
+
+// Replacement is a repository used instead of another one.
+type Replacement struct {
+	// Path is the import path of the replaced repository.
+	Path string
+	// Target is the URL or the local directory used instead.
+	Target string
+	// Local is set when Target is a local directory.
+	Local bool
+}
+
+// Progress is notified as the repositories of a phase are processed,
+// possibly from several goroutines at once. Repositories skipped after
+// a failure or a cancellation are given to Done with ErrSkipped,
+// without a call to Start.
+type Progress interface {
+	Start(name string)
+	Done(name string, err error)
+	Finish()
+}
+
+// ErrSkipped is the reason given to imports that were never attempted
+// because of an earlier failure or a cancellation.
+var ErrSkipped = errors.New("not attempted after an earlier failure")
+
+// errOffline is returned when a pin cannot be satisfied from the
+// local workspace in offline mode.
+type errOffline struct {
+	string
+}
+
+func (e *errOffline) Error() string {
+	return "cannot be satisfied offline: " + e.string
+}
+
+// errLinked is the reason given to imports linked to a local
+// directory, which are left untouched.
+type errLinked struct {
+	string
+}
+
+func (e *errLinked) Error() string {
+	return "linked to " + e.string
+}
+
+// Result is the outcome of syncing a single import.
+type Result struct {
+	Import
+	Err error
+}
+
+// Status returns "ok", "failed" or "skipped".
+func (r Result) Status() string {
+	if _, ok := r.Err.(*errLinked); ok {
+		return "skipped"
+	}
+	switch r.Err {
+	case nil:
+		return "ok"
+	case ErrSkipped:
+		return "skipped"
+	}
+	return "failed"
+}
+
+// Failed reports whether the import could not be synced, either
+// because it failed or because an earlier failure prevented it.
+func (r Result) Failed() bool {
+	_, linked := r.Err.(*errLinked)
+	return r.Err != nil && !linked
+}
+
+// Report is the outcome of Sync, with a result for each import in the
+// order they were given.
+type Report struct {
+	Results []Result
+}
+
+// Failed returns the number of imports that could not be synced.
+func (r Report) Failed() int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Failed() {
+			n++
+		}
+	}
+	return n
+}
+
+// Sync downloads and checks out every import. Imports in the same
+// repository are handled by a single operation. Unless
+// opts.KeepGoing is set no further repository is attempted once one
+// has failed, nor once ctx is cancelled. The error is ctx.Err() when
+// cancelled, or reports the number of failures otherwise.
+func Sync(ctx context.Context, imports []Import, opts SyncOptions) (Report, error) {
+	if len(opts.GOPATH) == 0 {
+		return Report{}, errors.New("GOPATH not set")
+	}
+	if opts.Install == "" {
+		opts.Install = opts.GOPATH[0]
+	}
+	if opts.VCS == nil {
+		opts.VCS = vcs.NewConfig()
+	}
+	s := &syncer{opts: opts}
+	results := make([]Result, len(imports))
+	for i, imp := range imports {
+		results[i].Import = imp
+		if opts.Linked == nil {
+			continue
+		}
+		if dir, ok := opts.Linked(imp.Package); ok {
+			results[i].Err = &errLinked{dir}
+		}
+	}
+
+	s.phase(ctx, "download", groupResults(results, s.downloadRoot),
+		func(ctx context.Context, g *repoGroup) error {
+			for _, res := range g.Members {
+				if err := s.download(ctx, res.Import); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+	if opts.Reset {
+		for i := range results {
+			results[i].Hash = ""
+		}
+	}
+	groups := groupResults(results, s.checkoutRoot)
+	for _, g := range groups {
+		if err := checkConflicts(g); err != nil {
+			for _, res := range g.Members {
+				res.Err = err
+			}
+			s.failed = true
+		}
+	}
+	var pending []*repoGroup
+	for _, g := range groups {
+		if g.Members[0].Err == nil {
+			pending = append(pending, g)
+		}
+	}
+	s.phase(ctx, "checkout", pending, func(ctx context.Context, g *repoGroup) error {
+		return s.checkout(ctx, g.Members[0].Import)
+	})
+
+	report := Report{Results: results}
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	if n := report.Failed(); n > 0 {
+		return report, fmt.Errorf("%d of %d imports failed", n, len(results))
+	}
+	return report, nil
+}
+
+// syncer holds the state of a Sync call.
+type syncer struct {
+	opts   SyncOptions
+	mu     sync.Mutex
+	failed bool
+}
+
+// repoGroup is a set of imports sharing one repository, which are
+// processed together so that the working tree is touched only once.
+type repoGroup struct {
+	Root    string
+	Members []*Result
+}
+
+// groupResults groups the results that have not failed yet by the
+// repository returned by root, keeping the order of first appearance.
+// Results for which root fails are marked as failed.
+func groupResults(results []Result, root func(Import) (string, error)) []*repoGroup {
+	var groups []*repoGroup
+	index := make(map[string]*repoGroup)
+	for i := range results {
+		res := &results[i]
+		if res.Err != nil {
+			continue
+		}
+		r, err := root(res.Import)
+		if err != nil {
+			res.Err = err
+			continue
+		}
+		g, ok := index[r]
+		if !ok {
+			g = &repoGroup{Root: r}
+			index[r] = g
+			groups = append(groups, g)
+		}
+		g.Members = append(g.Members, res)
+	}
+	return groups
+}
+
+// phase calls fn on every group, running at most opts.Jobs of them
+// at a time. The error of fn is recorded for all members.
+func (s *syncer) phase(ctx context.Context, label string, groups []*repoGroup,
+	fn func(context.Context, *repoGroup) error) {
+	var p Progress
+	if s.opts.Progress != nil {
+		p = s.opts.Progress(label, len(groups))
+		defer p.Finish()
+	}
+	jobs := s.opts.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for _, g := range groups {
+		sem <- struct{}{}
+		s.mu.Lock()
+		stop := s.failed && !s.opts.KeepGoing
+		s.mu.Unlock()
+		if stop || ctx.Err() != nil {
+			<-sem
+			for _, res := range g.Members {
+				res.Err = ErrSkipped
+			}
+			if p != nil {
+				p.Done(g.Root, ErrSkipped)
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(g *repoGroup) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if p != nil {
+				p.Start(g.Root)
+			}
+			err := runWithTimeout(ctx, s.opts.Timeout, g, fn)
+			if p != nil {
+				p.Done(g.Root, err)
+			}
+			if err != nil {
+				s.mu.Lock()
+				for _, res := range g.Members {
+					res.Err = err
+				}
+				s.failed = true
+				s.mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// checkConflicts returns an error if the members of g are pinned
+// to different revisions.
+func checkConflicts(g *repoGroup) error {
+	hash := g.Members[0].Hash
+	for _, res := range g.Members[1:] {
+		if res.Hash == hash {
+			continue
+		}
+		var pins []string
+		for _, res := range g.Members {
+			pins = append(pins, res.Package+" "+res.Hash)
+		}
+		return fmt.Errorf("conflicting revisions for repository %s: %s",
+			g.Root, strings.Join(pins, ", "))
+	}
+	return nil
+}
+
+// runWithTimeout calls fn with a context limited by timeout,
+// turning an expired deadline into a descriptive error.
+func runWithTimeout(ctx context.Context, timeout time.Duration, g *repoGroup,
+	fn func(context.Context, *repoGroup) error) error {
+	if timeout <= 0 {
+		return fn(ctx, g)
+	}
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := fn(tctx, g)
+	if err != nil && tctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
+
+// replacement returns the replacement of the repository of pkg.
+func (s *syncer) replacement(pkg string) (Replacement, bool) {
+	if s.opts.Replace == nil {
+		return Replacement{}, false
+	}
+	return s.opts.Replace(pkg)
+}
+
+// dir returns the directory of the import path pkg in the first entry
+// of GOPATH holding it, or in the install entry when none does.
+func (s *syncer) dir(pkg string) string {
+	pkg = strings.TrimSuffix(pkg, "/...")
+	if dir, ok := FindDir(s.opts.GOPATH, pkg); ok {
+		return dir
+	}
+	return filepath.Join(s.opts.Install, "src", filepath.FromSlash(pkg))
+}
+
+// downloadRoot guesses the repository of imp before it is downloaded,
+// so that packages of one repository are not cloned concurrently.
+func (s *syncer) downloadRoot(imp Import) (string, error) {
+	if r, ok := s.replacement(imp.Package); ok {
+		return r.Path, nil
+	}
+	if root, err := s.checkoutRoot(imp); err == nil {
+		return root, nil
+	}
+	parts := strings.Split(strings.TrimSuffix(imp.Package, "/..."), "/")
+	n := len(parts)
+	switch parts[0] {
+	case "github.com", "bitbucket.org", "gitlab.com", "golang.org":
+		n = 3
+	case "gopkg.in":
+		n = 2
+	}
+	if n > len(parts) {
+		n = len(parts)
+	}
+	return strings.Join(parts[:n], "/"), nil
+}
+
+// checkoutRoot returns the import path of the root of the repository
+// containing imp.
+func (s *syncer) checkoutRoot(imp Import) (string, error) {
+	v, err := vcs.New(s.dir(imp.Package), srcRoots(s.opts.GOPATH)...)
+	if err != nil {
+		return "", err
+	}
+	root, ok := ImportPath(s.opts.GOPATH, v.Root)
+	if !ok {
+		return filepath.ToSlash(v.Root), nil
+	}
+	return root, nil
+}
+
+// download makes the repository of imp available in GOPATH.
+func (s *syncer) download(ctx context.Context, imp Import) error {
+	if r, ok := s.replacement(imp.Package); ok {
+		return s.downloadReplacement(ctx, r)
+	}
+	if exists(s.dir(imp.Package)) {
+		return nil
+	}
+	if s.opts.VCS.Offline {
+		return &errOffline{"repository not present in GOPATH"}
+	}
+	err := s.opts.VCS.Execute(ctx, "", []string{"GOPATH=" + s.goPath()},
+		s.opts.Stdout, s.opts.Stderr, "go", "get", "-d", imp.Package)
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("go get -d: %s", err)
+	}
+	return err
+}
+
+// goPath returns the GOPATH of the go tool, listing the install entry
+// first for it to download there.
+func (s *syncer) goPath() string {
+	gopath := []string{s.opts.Install}
+	for _, entry := range s.opts.GOPATH {
+		if filepath.Clean(entry) != filepath.Clean(s.opts.Install) {
+			gopath = append(gopath, entry)
+		}
+	}
+	return strings.Join(gopath, string(filepath.ListSeparator))
+}
+
+// downloadReplacement makes the repository replaced by r available in
+// GOPATH, cloning the fork or linking the local directory.
+func (s *syncer) downloadReplacement(ctx context.Context, r Replacement) error {
+	dir := s.dir(r.Path)
+	if r.Local {
+		if target, err := os.Readlink(dir); err == nil && target == r.Target {
+			return nil
+		}
+		if exists(dir) {
+			return fmt.Errorf("%s exists, remove it to use the replacement %s", dir, r.Target)
+		}
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return err
+		}
+		return os.Symlink(r.Target, dir)
+	}
+	if exists(dir) {
+		return nil
+	}
+	if s.opts.VCS.Offline {
+		return &errOffline{"repository not present in GOPATH"}
+	}
+	return s.opts.VCS.Clone(ctx, r.Target, dir)
+}
+
+// checkout moves the repository of imp to its pinned revision, or to
+// the latest one when the hash is empty.
+func (s *syncer) checkout(ctx context.Context, imp Import) error {
+	r, replaced := s.replacement(imp.Package)
+	if replaced && r.Local {
+		// local directories are used as they are
+		return nil
+	}
+	v, err := vcs.New(s.dir(imp.Package), srcRoots(s.opts.GOPATH)...)
+	if err != nil {
+		return err
+	}
+	v = v.WithContext(ctx).WithConfig(s.opts.VCS)
+	if s.opts.VCS.Offline {
+		if imp.Hash == "" {
+			return &errOffline{"updating to the latest revision needs the network"}
+		}
+		if !v.HasRevision(imp.Hash) {
+			return &errOffline{"revision not available in local repository"}
+		}
+	}
+	if err := s.protectLocalWork(v, imp.Hash); err != nil {
+		return err
+	}
+	if imp.Hash != "" {
+		err = v.Checkout(imp.Hash)
+		if err != nil {
+			// try fetching for the latest commit
+			if replaced {
+				err = v.FetchFrom(r.Target)
+			} else {
+				err = v.Fetch()
+			}
+			if err != nil {
+				return err
+			}
+			err = v.Checkout(imp.Hash)
+			if err != nil {
+				return fmt.Errorf("error checking out: %s", err)
+			}
+		}
+		return nil
+	}
+	if err := v.Latest(); err != nil {
+		return fmt.Errorf("error trying to set to latest: %s", err)
+	}
+	return nil
+}
+
+// protectLocalWork returns an error when moving the repository v to
+// hash, or to the latest revision when hash is empty, could lose
+// uncommitted changes or unpushed commits, stashing the changes first
+// if opts.Stash is set.
+func (s *syncer) protectLocalWork(v *vcs.VCS, hash string) error {
+	if hash != "" {
+		if current, err := v.CommitHash(); err == nil && current == hash {
+			return nil
+		}
+	}
+	status, err := v.Status()
+	if err != nil {
+		return fmt.Errorf("error reading status: %s", err)
+	}
+	if status.Modified && s.opts.Stash {
+		if err := v.Stash(); err != nil {
+			return fmt.Errorf("error stashing changes: %s", err)
+		}
+		status.Modified = false
+	}
+	switch {
+	case s.opts.Force:
+		return nil
+	case status.Modified:
+		return errors.New("repository has uncommitted changes, use -stash or -force")
+	case status.Unpushed:
+		return errors.New("repository has unpushed commits, use -force")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package deps
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/satran/goimp/vcs"
+)
+
+func TestCheckConflicts(t *testing.T) {
+	g := &repoGroup{Root: "golang.org/x/net", Members: []*Result{
+		{Import: Import{"golang.org/x/net/context", "abc"}},
+		{Import: Import{"golang.org/x/net/html", "abc"}},
+	}}
+	if err := checkConflicts(g); err != nil {
+		t.Fatalf("unexpected conflict: %s", err)
+	}
+	g.Members[1].Hash = "def"
+	if err := checkConflicts(g); err == nil {
+		t.Fatal("expected a conflict for different revisions")
+	}
+}
+
+func TestSyncOffline(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	gopath, err := ioutil.TempDir("", "goimp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	repo := filepath.Join(gopath, "src", "example.com", "lib")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	var revs []string
+	for _, content := range []string{"package lib\n", "package lib // v2\n"} {
+		if err := ioutil.WriteFile(filepath.Join(repo, "lib.go"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "lib.go")
+		git("commit", "-q", "-m", "lib")
+		revs = append(revs, git("rev-parse", "HEAD"))
+	}
+
+	conf := vcs.NewConfig()
+	conf.Offline = true
+	imports := []Import{
+		{Package: "example.com/lib", Hash: revs[0]},
+		{Package: "example.com/linked", Hash: revs[0]},
+		{Package: "example.com/missing", Hash: revs[0]},
+	}
+	report, err := Sync(context.Background(), imports, SyncOptions{
+		GOPATH:    []string{gopath},
+		VCS:       conf,
+		KeepGoing: true,
+		Linked: func(pkg string) (string, bool) {
+			return "../linked", pkg == "example.com/linked"
+		},
+	})
+	if err == nil {
+		t.Error("expected an error for the missing repository")
+	}
+	for i, want := range []string{"ok", "skipped", "failed"} {
+		if got := report.Results[i].Status(); got != want {
+			t.Errorf("%s: status %s (%v), want %s", imports[i].Package, got, report.Results[i].Err, want)
+		}
+	}
+	if n := report.Failed(); n != 1 {
+		t.Errorf("Failed() = %d, want 1", n)
+	}
+	if head := git("rev-parse", "HEAD"); head != revs[0] {
+		t.Errorf("checked out %s, want %s", head, revs[0])
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/satran/goimp/deps"
+	"github.com/satran/goimp/vcs"
+)
+
+var cmdEnv = &Command{
+	UsageLine: "env [-sync] [-offline] [-file] [-p]",
+	Short:     "prints the environment of the project GOPATH",
+	Long: `prints the environment of the project GOPATH
+
+Every project gets its own GOPATH, kept in the cache directory of the
+project configuration, where the dependencies of the Godeps file are
+checked out. It comes before the GOPATH of the environment, so that
+the project itself is still found there. GO111MODULE is turned off for
+the go tool to use it.
+
+env brings the project GOPATH in line with the Godeps file as 'goimp
+get' does and prints the variables using it, to be evaluated by a
+POSIX shell:
+
+	eval "$(goimp env)"
+
+-sync	gets the dependencies before printing, defaults to true
+-offline	never access the network, defaults to true when
+	GOIMP_OFFLINE is set
+-file	file to get commits from, defaults to Godeps
+-p	specify the directory of the package, by default it is "."
+`,
+}
+
+var cmdShell = &Command{
+	UsageLine: "shell [-sync] [-offline] [-file] [-p] [command args...]",
+	Short:     "runs a shell or command in the project GOPATH",
+	Long: `runs a shell or command in the project GOPATH
+
+shell prepares the project GOPATH as 'goimp env' does and runs the
+command in it, or $SHELL when none is given. The exit status is the
+one of the command.
+
+-sync	gets the dependencies before running, defaults to true
+-offline	never access the network, defaults to true when
+	GOIMP_OFFLINE is set
+-file	file to get commits from, defaults to Godeps
+-p	specify the directory of the package, by default it is "."
+`,
+}
+
+func init() {
+	cmdEnv.Run = runEnv // break init loop
+	addProjectFlags(&cmdEnv.Flag, "p", "file")
+	cmdShell.Run = runShell
+	addProjectFlags(&cmdShell.Flag, "p", "file")
+}
+
+var (
+	envSync      = cmdEnv.Flag.Bool("sync", true, "get the dependencies")
+	envOffline   = cmdEnv.Flag.Bool("offline", offlineDefault(), "forbid network access")
+	shellSync    = cmdShell.Flag.Bool("sync", true, "get the dependencies")
+	shellOffline = cmdShell.Flag.Bool("offline", offlineDefault(), "forbid network access")
+)
+
+func runEnv(cmd *Command, args []string) {
+	vcs.Default.Offline = *envOffline
+	env := projectEnv(*envSync)
+	for _, kv := range env {
+		i := strings.Index(kv, "=")
+		fmt.Printf("export %s=%s\n", kv[:i], shellQuote(kv[i+1:]))
+	}
+}
+
+func runShell(cmd *Command, args []string) {
+	vcs.Default.Offline = *shellOffline
+	env := projectEnv(*shellSync)
+	if len(args) == 0 {
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		args = []string{shell}
+		env = append(env, "PS1=[go] "+filepath.Base(projectRoot())+"=> ")
+	}
+	c := exec.Command(args[0], args[1:]...)
+	c.Env = append(os.Environ(), env...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			os.Exit(exit.ExitCode())
+		}
+		elog.Fatal(err)
+	}
+}
+
+// envGoPath returns the GOPATH directory of the project.
+func envGoPath() string {
+	root := projectRoot()
+	sum := sha256.Sum256([]byte(root))
+	name := fmt.Sprintf("%s-%x", filepath.Base(root), sum[:4])
+	if project.Cache == "" {
+		return filepath.Join(project.StateDir(), "gopath")
+	}
+	return filepath.Join(project.Cache, "gopath", name)
+}
+
+// projectEnv returns the "key=value" variables using the project
+// GOPATH, after getting the dependencies into it if sync is set.
+func projectEnv(sync bool) []string {
+	dir := envGoPath()
+	if err := os.MkdirAll(filepath.Join(dir, "src"), 0755); err != nil {
+		elog.Fatal(err)
+	}
+	if sync && exists(project.DepsFile()) {
+		syncEnv(dir)
+	}
+
+	gopath := []string{dir}
+	for _, p := range filepath.SplitList(goPathEnv) {
+		if p != "" && p != dir {
+			gopath = append(gopath, p)
+		}
+	}
+	bin := filepath.Join(dir, "bin")
+	path := os.Getenv("PATH")
+	if !strings.HasPrefix(path, bin+string(filepath.ListSeparator)) {
+		path = bin + string(filepath.ListSeparator) + path
+	}
+	return []string{
+		"GOPATH=" + strings.Join(gopath, string(filepath.ListSeparator)),
+		"GO111MODULE=off",
+		"PATH=" + path,
+		"GOIMP_ENV=" + projectRoot(),
+	}
+}
+
+// syncEnv gets the dependencies of the Godeps file into the GOPATH
+// dir, reporting failures without stopping.
+func syncEnv(dir string) {
+	opts := syncOptions()
+	opts.GOPATH = []string{dir}
+	opts.Install = dir
+	opts.KeepGoing = true
+	opts.Progress = showProgress
+	// standard output is for the shell, keep go get from writing there
+	opts.Stdout = os.Stderr
+	report, _ := deps.Sync(context.Background(), getImportsFromFile(project.DepsFile()), opts)
+	for _, res := range report.Results {
+		if res.Failed() {
+			elog.Printf("%s: %s", res.Package, res.Err)
+		}
+	}
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
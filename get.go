@@ -1,39 +1,97 @@
 package main
 
 import (
-	"io/ioutil"
+	"context"
+	"fmt"
+	"io"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strings"
-	"sync"
+	"text/tabwriter"
+	"time"
 
+	"github.com/satran/goimp/deps"
 	"github.com/satran/goimp/vcs"
 )
 
 var cmdGet = &Command{
-	UsageLine: "get [-file] [-reset] [-p] [package_url commit]",
+	UsageLine: "get [-file] [-reset] [-force] [-stash] [-gopath] [-offline] [-keep-going] [-j] [-timeout] [-retries] [-retry-delay] [-p] [package_url commit]",
 	Short:     "gets imports of the package",
 	Long: `gets imports of the package
 
 -p	specify the directory of the package, by default it is "."
 -file	file to get commits from, defaults to Godeps
 -reset	fetches the lastest code in the master branch
+-force	checks out repositories with uncommitted changes or unpushed
+	commits, which are otherwise refused
+-stash	sets uncommitted changes aside with git stash, hg shelve or
+	bzr shelve before checking out
+-gopath	entry of GOPATH new repositories are downloaded into, defaults
+	to the first one. Repositories are looked up in every entry
+-offline	never access the network, only use repositories already
+	present in GOPATH. Defaults to true when GOIMP_OFFLINE is set.
+	Pins that cannot be satisfied are reported at the end.
+-keep-going	continue with the remaining packages after a failure,
+	by default no new package is started after the first failure
+-j	number of packages processed concurrently, defaults to 4 or the
+	jobs of the project configuration
+-timeout	time allowed for each repository operation, 0 means no
+	limit, defaults to 5m
+-retries	number of times a command failing with a network error is
+	retried, defaults to 2
+-retry-delay	wait before the first retry, doubled for each further
+	retry, defaults to 1s
+
+Progress is shown on standard error, as a status line when it is a
+terminal and as one line per package otherwise. An interrupt cancels
+the run and kills any running version control command, a second one
+exits at once.
+
+Private repositories are reached with the auth settings of the
+project configuration: per host URL rewrites, credentials from the
+netrc file and git credential helpers.
+
+Packages linked to a local directory with 'goimp link' are skipped.
+
+Repositories matching a replace rule of the project configuration are
+cloned from the given fork, which is also used to fetch missing
+revisions, or linked to the given local directory which is then left
+untouched.
+
+A summary of every package is printed once done and the exit status
+is non-zero if any of them failed.
 `,
 }
 
 func init() {
 	cmdGet.Run = runGet // break init loop
+	addProjectFlags(&cmdGet.Flag, "p", "file")
 }
 
 var (
-	getDir   = cmdGet.Flag.String("p", ".", "path of the go package")
-	getFile  = cmdGet.Flag.String("file", "Godeps", "file to get to")
-	getReset = cmdGet.Flag.Bool("reset", false, "fetches the latest code in the master branch")
+	getReset     = cmdGet.Flag.Bool("reset", false, "fetches the latest code in the master branch")
+	getForce     = cmdGet.Flag.Bool("force", false, "check out repositories with local work")
+	getStash     = cmdGet.Flag.Bool("stash", false, "stash uncommitted changes before checking out")
+	getGoPath    = cmdGet.Flag.String("gopath", "", "GOPATH entry to download into")
+	getOffline   = cmdGet.Flag.Bool("offline", offlineDefault(), "forbid network access")
+	getKeepGoing = cmdGet.Flag.Bool("keep-going", false, "continue after a failure")
+	getJobs      = cmdGet.Flag.Int("j", 0, "number of concurrent jobs")
+	getTimeout   = cmdGet.Flag.Duration("timeout", 5*time.Minute, "timeout of each repository operation")
+	getRetries   = cmdGet.Flag.Int("retries", vcs.Default.Retries, "retries of transient failures")
+	getRetryWait = cmdGet.Flag.Duration("retry-delay", vcs.Default.RetryDelay, "initial delay between retries")
 )
 
 func runGet(cmd *Command, args []string) {
 	var imports []Import
+	vcs.Default.Offline = *getOffline
+	vcs.Default.Retries = *getRetries
+	vcs.Default.RetryDelay = *getRetryWait
+	if *getGoPath != "" {
+		if err := setInstallPath(*getGoPath); err != nil {
+			elog.Fatal(err)
+		}
+	}
 
 	if len(args) > 0 {
 		pkg := args[0]
@@ -48,98 +106,104 @@ func runGet(cmd *Command, args []string) {
 			{Package: pkg, Hash: hash},
 		}
 	} else {
-		imports = getImportsFromFile(*getDir, *getFile)
-	}
-
-	var wg sync.WaitGroup
-	wg.Add(len(imports))
-	for _, imp := range imports {
-		go func(imp Import) {
-			getDependencies(imp)
-			wg.Done()
-		}(imp)
+		imports = getImportsFromFile(project.DepsFile())
 	}
-	wg.Wait()
 
-	wg.Add(len(imports))
-	for _, imp := range imports {
-		if *getReset {
-			imp.Hash = ""
-		}
-		go func(imp Import) {
-			get(imp)
-			wg.Done()
-		}(imp)
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+		<-interrupt
+		elog.Fatal("interrupted")
+	}()
+
+	opts := syncOptions()
+	opts.Reset = *getReset
+	opts.Force = *getForce
+	opts.Stash = *getStash
+	opts.KeepGoing = *getKeepGoing
+	opts.Progress = showProgress
+	report, err := deps.Sync(ctx, imports, opts)
+	signal.Stop(interrupt)
+	cancel()
+	printGetResults(os.Stdout, report.Results)
+	if err != nil {
+		os.Exit(1)
 	}
-	wg.Wait()
 }
 
-func getImportsFromFile(dir, file string) []Import {
-	path := filepath.Join(dir, file)
-
-	content, err := ioutil.ReadFile(path)
+// syncOptions returns the options of deps.Sync shared by the commands
+// getting dependencies: the workspace, the -j and -timeout flags and
+// the links and replace rules of the project.
+func syncOptions() deps.SyncOptions {
+	links, err := readLinks()
 	if err != nil {
-		elog.Fatalf("error reading deps file: %s", err)
+		elog.Print(err)
 	}
+	return deps.SyncOptions{
+		GOPATH:  goPath(),
+		Install: filepath.Dir(installSrc()),
+		VCS:     vcs.Default,
+		Jobs:    jobs(),
+		Timeout: *getTimeout,
+		Replace: project.Replacement,
+		Linked: func(pkg string) (string, bool) {
+			return linkedDir(links, pkg)
+		},
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+}
 
-	var ret []Import
-	lines := strings.Split(strings.Trim(string(content), "\n"), "\n")
-	for _, line := range lines {
-		splits := strings.Fields(line)
-		pkg := splits[0]
-		var hash string
-		if len(splits) > 1 {
-			hash = splits[1]
+// showProgress reports the progress of a deps.Sync phase on standard
+// error.
+func showProgress(label string, total int) deps.Progress {
+	return newProgress(os.Stderr, label, total)
+}
+
+// printGetResults writes a summary table of results to w.
+func printGetResults(w io.Writer, results []deps.Result) {
+	counts := make(map[string]int)
+	tw := new(tabwriter.Writer)
+	tw.Init(w, 0, 8, 1, ' ', 0)
+	for _, res := range results {
+		status := res.Status()
+		counts[status]++
+		reason := ""
+		if res.Err != nil {
+			reason = strings.Replace(res.Err.Error(), "\n", " ", -1)
 		}
-		ret = append(ret, Import{pkg, hash})
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+			status, res.Package, res.Hash, strings.TrimSpace(reason))
 	}
-	return ret
+	tw.Flush()
+	fmt.Fprintf(w, "%d ok, %d failed, %d skipped\n",
+		counts["ok"], counts["failed"], counts["skipped"])
 }
 
-func getDependencies(imp Import) {
-	vcspath := filepath.Join(goPathSrc,
-		strings.TrimRight(imp.Package, "/..."))
-	if !exists(vcspath) {
-		cmd := exec.Command("go", "get", "-d", imp.Package)
-		cmd.Stderr = os.Stderr
-		cmd.Stdout = os.Stdout
-		cmd.Stdin = os.Stdin
-		err := cmd.Run()
-		if err != nil {
-			elog.Print(err)
-			return
-		}
+// jobs returns the number of concurrent jobs set by -j or the
+// project configuration.
+func jobs() int {
+	if *getJobs > 0 {
+		return *getJobs
 	}
+	return project.Jobs
 }
 
-func get(imp Import) {
-	vcspath := filepath.Join(goPathSrc,
-		strings.TrimRight(imp.Package, "/..."))
-	v, err := vcs.New(vcspath, goPathSrc)
+// getImportsFromFile returns the imports of the deps file at path.
+func getImportsFromFile(path string) []Import {
+	imports, err := deps.ReadFile(path)
 	if err != nil {
-		elog.Print(err)
-		return
-	}
-	if imp.Hash != "" {
-		err = v.Checkout(imp.Hash)
-		if err != nil {
-			// try fetching for the latest commit
-			err = v.Fetch()
-			if err != nil {
-				elog.Print(err)
-				return
-			}
-			err = v.Checkout(imp.Hash)
-			if err != nil {
-				elog.Printf("error checkout out %s: %s", imp.Package, err)
-				return
-			}
-		}
-		return
-	}
-	if err := v.Latest(); err != nil {
-		elog.Printf("error trying to set %s to latest: %s", imp.Package, err)
+		elog.Fatalf("error reading deps file: %s", err)
 	}
+	return imports
+}
+
+// importDir returns the directory of imp inside GOPATH.
+func importDir(imp Import) string {
+	return srcDir(strings.TrimSuffix(imp.Package, "/..."))
 }
 
 func exists(dir string) bool {
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// importChange is the change of revision of a dependency.
+type importChange struct {
+	Package string
+	Old     string
+	New     string
+}
+
+// diffImports returns the changes from old to new, sorted by package.
+func diffImports(old, new map[string]string) []importChange {
+	var changes []importChange
+	for pkg, hash := range new {
+		if prev, ok := old[pkg]; !ok || prev != hash {
+			changes = append(changes, importChange{pkg, old[pkg], hash})
+		}
+	}
+	for pkg, hash := range old {
+		if _, ok := new[pkg]; !ok {
+			changes = append(changes, importChange{pkg, hash, ""})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Package < changes[j].Package
+	})
+	return changes
+}
+
+// runHook runs the shell command hook in the project root for event,
+// describing changes in the environment:
+//
+//	GOIMP_EVENT	the event, get or write
+//	GOIMP_PACKAGES	the changed packages, separated by spaces
+//	GOIMP_CHANGES	one "package old new" line per change, "-"
+//			standing for a missing revision
+//
+// A failing hook is only logged.
+func runHook(hook, event string, changes []importChange) {
+	if hook == "" || len(changes) == 0 {
+		return
+	}
+	var pkgs, lines []string
+	for _, c := range changes {
+		pkgs = append(pkgs, c.Package)
+		lines = append(lines, fmt.Sprintf("%s %s %s", c.Package, orDash(c.Old), orDash(c.New)))
+	}
+	cmd := exec.Command("sh", "-c", hook)
+	cmd.Dir = projectRoot()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"GOIMP_EVENT="+event,
+		"GOIMP_PACKAGES="+strings.Join(pkgs, " "),
+		"GOIMP_CHANGES="+strings.Join(lines, "\n"))
+	if err := cmd.Run(); err != nil {
+		log.Printf("%s hook %q failed: %s", event, hook, err)
+	}
+}
+
+// orDash returns the revision s, or "-" when it is missing.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
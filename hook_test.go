@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goimp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(c *Config) { project = c }(project)
+	project = defaultConfig()
+	project.Root = dir
+
+	runHook(`echo "$(pwd -P) $GOIMP_EVENT $GOIMP_PACKAGES" > out`, "get", []importChange{
+		{"example.com/a", "", "abc"},
+		{"example.com/b", "abc", "def"},
+	})
+	out, err := ioutil.ReadFile(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatalf("hook did not run in the project root: %s", err)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := root + " get example.com/a example.com/b\n"
+	if got := string(out); got != want {
+		t.Fatalf("hook wrote %q, want %q", got, want)
+	}
+}
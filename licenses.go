@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/satran/goimp/vcs"
+)
+
+var cmdLicenses = &Command{
+	UsageLine: "licenses [-format] [-allow] [-deny] [-p] [-tags]",
+	Short:     "lists the licenses of the dependencies",
+	Long: `lists the licenses of the dependencies
+
+For every repository reached by 'goimp list' the license file at its
+root is located and classified as one of MIT, ISC, BSD-2-Clause,
+BSD-3-Clause, Apache-2.0, MPL-2.0, GPL-2.0, GPL-3.0, LGPL-2.1,
+LGPL-3.0, AGPL-3.0, Unlicense, or unknown.
+
+-format	output format, csv or json, defaults to csv
+-allow	comma separated licenses allowed, any other one is reported
+-deny	comma separated licenses denied
+-p	specify the directory of the package, by default it is "."
+-tags	comma separated build tags used to select the files parsed
+
+The policy defaults to the allow and deny lists of the licenses table
+of the project configuration. The exit status is non-zero when a
+license breaks the policy.
+`,
+}
+
+func init() {
+	cmdLicenses.Run = runLicenses // break init loop
+	addProjectFlags(&cmdLicenses.Flag, "p", "tags")
+}
+
+var (
+	licensesFormat = cmdLicenses.Flag.String("format", "csv", "output format, csv or json")
+	licensesAllow  = cmdLicenses.Flag.String("allow", "", "comma separated licenses allowed")
+	licensesDeny   = cmdLicenses.Flag.String("deny", "", "comma separated licenses denied")
+)
+
+// licenseInfo is the license of a repository.
+type licenseInfo struct {
+	Repository string `json:"repository"`
+	Revision   string `json:"revision"`
+	License    string `json:"license"`
+	File       string `json:"file,omitempty"`
+	Violation  string `json:"violation,omitempty"`
+}
+
+// licenseFiles are the names of license files, lower cased.
+var licenseFiles = []string{
+	"license", "license.txt", "license.md", "licence", "licence.txt",
+	"licence.md", "copying", "copying.txt", "copying.md", "unlicense",
+}
+
+func runLicenses(cmd *Command, args []string) {
+	allow, deny := project.Licenses.Allow, project.Licenses.Deny
+	if *licensesAllow != "" {
+		allow = splitList(*licensesAllow)
+	}
+	if *licensesDeny != "" {
+		deny = splitList(*licensesDeny)
+	}
+
+	infos := scanLicenses(list(project.Packages, true, true))
+	failed := false
+	for i := range infos {
+		infos[i].Violation = licenseViolation(infos[i].License, allow, deny)
+		if infos[i].Violation != "" {
+			failed = true
+		}
+	}
+
+	var err error
+	switch *licensesFormat {
+	case "csv":
+		err = writeLicensesCSV(os.Stdout, infos)
+	case "json":
+		err = writeJSON(os.Stdout, infos)
+	default:
+		elog.Fatalf("unknown format %q", *licensesFormat)
+	}
+	if err != nil {
+		elog.Fatal(err)
+	}
+	if failed {
+		for _, info := range infos {
+			if info.Violation != "" {
+				elog.Printf("%s: %s", info.Repository, info.Violation)
+			}
+		}
+		os.Exit(1)
+	}
+}
+
+// scanLicenses returns the license of each repository of imports,
+// sorted by repository.
+func scanLicenses(imports []Import) []licenseInfo {
+	seen := newSet()
+	var infos []licenseInfo
+	for _, imp := range imports {
+		v, err := vcs.New(importDir(imp), goPathSrcs...)
+		if err != nil {
+			elog.Print(err)
+			continue
+		}
+		if seen.Contains(v.Root) {
+			continue
+		}
+		seen.Add(v.Root)
+		info := licenseInfo{
+			Repository: pkgPath(v.Root),
+			Revision:   imp.Hash,
+			License:    "unknown",
+		}
+		if file, text, ok := findLicense(v.Root); ok {
+			info.File = file
+			info.License = classifyLicense(text)
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Repository < infos[j].Repository
+	})
+	return infos
+}
+
+// findLicense returns the name and content of the license file in dir.
+func findLicense(dir string) (string, string, bool) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", "", false
+	}
+	for _, name := range licenseFiles {
+		for _, fi := range files {
+			if fi.IsDir() || strings.ToLower(fi.Name()) != name {
+				continue
+			}
+			content, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+			if err != nil {
+				continue
+			}
+			return fi.Name(), string(content), true
+		}
+	}
+	return "", "", false
+}
+
+// licenseHead is the length of the start of a license text searched
+// for its title, which leaves room for a copyright line before it.
+const licenseHead = 1000
+
+// licenseRules identify licenses by phrases of their title, searched
+// at the start of the text, and of their body, all of which must be
+// present and none of the excluded ones. Licenses mentioning each
+// other in their body, such as the GPL family and the MPL, are told
+// apart by their title only. The rules are tried in order.
+var licenseRules = []struct {
+	id       string
+	title    []string
+	phrases  []string
+	excluded []string
+}{
+	{"AGPL-3.0", []string{"gnu affero general public license"}, nil, nil},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}, nil, nil},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}, nil, nil},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}, nil, nil},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}, nil, nil},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}, nil, nil},
+	{"Apache-2.0", []string{"apache license", "version 2.0"}, nil, nil},
+	{"MIT", nil, []string{"permission is hereby granted, free of charge, to any person obtaining a copy"}, nil},
+	{"ISC", nil, []string{"permission to use, copy, modify, and/or distribute this software for any purpose"}, nil},
+	{"BSD-3-Clause", nil, []string{"redistribution and use in source and binary forms", "neither the name"}, nil},
+	{"BSD-3-Clause", nil, []string{"redistribution and use in source and binary forms", "names of its contributors"}, nil},
+	{"BSD-2-Clause", nil, []string{"redistribution and use in source and binary forms"}, []string{"neither the name", "names of its contributors"}},
+	{"Unlicense", nil, []string{"this is free and unencumbered software released into the public domain"}, nil},
+}
+
+// classifyLicense returns the SPDX identifier of the license text, or
+// unknown.
+func classifyLicense(text string) string {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	head := text
+	if len(head) > licenseHead {
+		head = head[:licenseHead]
+	}
+	for _, rule := range licenseRules {
+		if containsAll(head, rule.title) && containsAll(text, rule.phrases) &&
+			!containsAny(text, rule.excluded) {
+			return rule.id
+		}
+	}
+	return "unknown"
+}
+
+func containsAll(s string, subs []string) bool {
+	for _, sub := range subs {
+		if !strings.Contains(s, sub) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// licenseViolation returns why license breaks the policy, if it does.
+// When allow is given only the licenses it lists pass.
+func licenseViolation(license string, allow, deny []string) string {
+	for _, id := range deny {
+		if strings.EqualFold(id, license) {
+			return fmt.Sprintf("license %s is denied", license)
+		}
+	}
+	if len(allow) == 0 {
+		return ""
+	}
+	for _, id := range allow {
+		if strings.EqualFold(id, license) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("license %s is not allowed", license)
+}
+
+func writeLicensesCSV(w io.Writer, infos []licenseInfo) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"repository", "revision", "license", "file", "violation"})
+	for _, info := range infos {
+		cw.Write([]string{info.Repository, info.Revision, info.License, info.File, info.Violation})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeJSON writes v to w as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// splitList splits a comma separated list, dropping empty elements.
+func splitList(s string) []string {
+	var list []string
+	for _, elem := range strings.Split(s, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			list = append(list, elem)
+		}
+	}
+	return list
+}
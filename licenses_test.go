@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassifyLicense(t *testing.T) {
+	for text, id := range map[string]string{
+		`Permission is hereby granted, free of charge, to any person
+obtaining a copy of this software`: "MIT",
+		`Redistribution and use in source and binary forms, with or without
+modification, are permitted. Neither the name of Google Inc. nor`: "BSD-3-Clause",
+		`Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that`: "BSD-2-Clause",
+		`Apache License
+Version 2.0, January 2004`: "Apache-2.0",
+		`GNU LESSER GENERAL PUBLIC LICENSE Version 2.1, February 1999`: "LGPL-2.1",
+		`GNU GENERAL PUBLIC LICENSE Version 3, 29 June 2007`:           "GPL-3.0",
+		`All rights reserved.`: "unknown",
+	} {
+		if got := classifyLicense(text); got != id {
+			t.Errorf("classifyLicense(%q) = %s, want %s", text, got, id)
+		}
+	}
+}
+
+// TestClassifyLicenseTexts classifies the full texts of licenses that
+// mention each other, each file being named after its identifier.
+func TestClassifyLicenseTexts(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "licenses", "*"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no license texts: %v", err)
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := filepath.Base(file)
+		if got := classifyLicense(string(content)); got != id {
+			t.Errorf("classifyLicense(%s) = %s", id, got)
+		}
+		text := "Copyright (c) 2020 The Authors\n\n" + string(content)
+		if got := classifyLicense(text); got != id {
+			t.Errorf("classifyLicense(%s with copyright) = %s", id, got)
+		}
+	}
+	notice := `This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License.`
+	if got := classifyLicense(notice); got != "AGPL-3.0" {
+		t.Errorf("classifyLicense(AGPL notice) = %s", got)
+	}
+}
+
+func TestLicenseViolation(t *testing.T) {
+	allow := []string{"MIT", "Apache-2.0"}
+	if v := licenseViolation("mit", allow, nil); v != "" {
+		t.Errorf("MIT should be allowed: %s", v)
+	}
+	if v := licenseViolation("unknown", allow, nil); v == "" {
+		t.Error("unknown should not be allowed")
+	}
+	if v := licenseViolation("GPL-3.0", nil, []string{"GPL-3.0"}); v == "" {
+		t.Error("GPL-3.0 should be denied")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/satran/goimp/vcs"
+)
+
+var cmdLink = &Command{
+	UsageLine: "link [-d] [-p] [package_url local_dir]",
+	Short:     "uses a local directory for a dependency",
+	Long: `uses a local directory for a dependency
+
+link makes the repository of package_url in GOPATH a symbolic link
+to local_dir, so that a dependency can be developed alongside the
+package. package_url must be the root of its repository, linking a
+package inside a checked out repository is refused. Linked packages are skipped by get, and write warns while
+links are active since the Godeps file then records the revisions
+of the local directories. An existing checkout is kept aside and
+restored when the link is removed.
+
+Without arguments the active links are listed.
+
+-d	removes the link of package_url
+-p	specify the directory of the package, by default it is "."
+`,
+}
+
+func init() {
+	cmdLink.Run = runLink // break init loop
+	addProjectFlags(&cmdLink.Flag, "p")
+}
+
+var (
+	linkDelete = cmdLink.Flag.Bool("d", false, "remove the link")
+)
+
+// linkBackupSuffix is appended to the checkout kept aside by link.
+const linkBackupSuffix = ".goimp-unlinked"
+
+func runLink(cmd *Command, args []string) {
+	links, err := readLinks()
+	if err != nil {
+		elog.Fatal(err)
+	}
+	switch {
+	case len(args) == 0 && !*linkDelete:
+		w := new(tabwriter.Writer)
+		w.Init(os.Stdout, 0, 8, 0, '\t', 0)
+		for _, pkg := range sortedKeys(links) {
+			fmt.Fprintf(w, "%s\t%s\n", pkg, links[pkg])
+		}
+		w.Flush()
+		return
+	case len(args) == 1 && *linkDelete:
+		pkg := strings.TrimSuffix(args[0], "/...")
+		if _, ok := links[pkg]; !ok {
+			elog.Fatalf("%s is not linked", pkg)
+		}
+		if err := unlinkPackage(pkg); err != nil {
+			elog.Fatal(err)
+		}
+		delete(links, pkg)
+	case len(args) == 2 && !*linkDelete:
+		pkg := strings.TrimSuffix(args[0], "/...")
+		dir, err := filepath.Abs(args[1])
+		if err != nil {
+			elog.Fatal(err)
+		}
+		if !exists(dir) {
+			elog.Fatalf("%s does not exist", dir)
+		}
+		if err := linkPackage(pkg, dir); err != nil {
+			elog.Fatal(err)
+		}
+		links[pkg] = dir
+	default:
+		cmd.Usage()
+	}
+	if err := writeLinks(links); err != nil {
+		elog.Fatal(err)
+	}
+}
+
+// linkPackage points the repository of pkg in GOPATH to dir, moving
+// an existing checkout aside. pkg must not be inside a repository,
+// which would be left with uncommitted changes.
+func linkPackage(pkg, dir string) error {
+	path := srcDir(pkg)
+	if v, err := vcs.New(path, goPathSrcs...); err == nil {
+		if root := pkgPath(v.Root); root != pkg {
+			return fmt.Errorf("%s is inside the repository %s, link the repository instead", pkg, root)
+		}
+	}
+	if target, err := os.Readlink(path); err == nil {
+		if target == dir {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	} else if exists(path) {
+		if exists(path + linkBackupSuffix) {
+			return fmt.Errorf("%s exists, cannot keep %s aside", path+linkBackupSuffix, path)
+		}
+		if err := os.Rename(path, path+linkBackupSuffix); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(dir, path)
+}
+
+// unlinkPackage removes the link of pkg, restoring the checkout kept
+// aside by linkPackage.
+func unlinkPackage(pkg string) error {
+	path := srcDir(pkg)
+	if _, err := os.Readlink(path); err == nil {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	if exists(path + linkBackupSuffix) {
+		return os.Rename(path+linkBackupSuffix, path)
+	}
+	return nil
+}
+
+// linksFile returns the path of the file recording the links.
+func linksFile() string {
+	return filepath.Join(project.StateDir(), "links")
+}
+
+// readLinks returns the active links, mapping the import path of a
+// repository to its local directory.
+func readLinks() (map[string]string, error) {
+	links := make(map[string]string)
+	f, err := os.Open(linksFile())
+	if os.IsNotExist(err) {
+		return links, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) == 2 {
+			links[fields[0]] = fields[1]
+		}
+	}
+	return links, scanner.Err()
+}
+
+// writeLinks records links, removing the file when there are none.
+func writeLinks(links map[string]string) error {
+	if len(links) == 0 {
+		err := os.Remove(linksFile())
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.MkdirAll(project.StateDir(), 0755); err != nil {
+		return err
+	}
+	var content []byte
+	for _, pkg := range sortedKeys(links) {
+		content = append(content, pkg+"\t"+links[pkg]+"\n"...)
+	}
+	return ioutil.WriteFile(linksFile(), content, 0644)
+}
+
+// linkedDir returns the local directory linked for the import path
+// pkg, if any.
+func linkedDir(links map[string]string, pkg string) (string, bool) {
+	for root, dir := range links {
+		if pkg == root || strings.HasPrefix(pkg, root+"/") {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
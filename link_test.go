@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLinkPackageInRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goimp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(src string, srcs []string) { goPathSrc, goPathSrcs = src, srcs }(goPathSrc, goPathSrcs)
+	goPathSrc = filepath.Join(dir, "src")
+	goPathSrcs = []string{goPathSrc}
+	repo := filepath.Join(goPathSrc, "golang.org", "x", "net")
+	for _, d := range []string{filepath.Join(repo, ".git"), filepath.Join(repo, "context")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	local := filepath.Join(dir, "local")
+
+	err = linkPackage("golang.org/x/net/context", local)
+	if err == nil || !strings.Contains(err.Error(), "golang.org/x/net") {
+		t.Fatalf("linkPackage of a package inside a repository = %v", err)
+	}
+	if fi, err := os.Lstat(filepath.Join(repo, "context")); err != nil || !fi.IsDir() {
+		t.Fatalf("package directory was changed: %v", err)
+	}
+	if err := linkPackage("golang.org/x/net", local); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(repo); err != nil || target != local {
+		t.Fatalf("repository link = %q, %v", target, err)
+	}
+}
@@ -1,214 +1,136 @@
 package main
 
 import (
-	"errors"
 	"fmt"
-	"go/ast"
-	"go/parser"
-	"go/token"
-	"io/ioutil"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"text/tabwriter"
 
+	"github.com/satran/goimp/deps"
 	"github.com/satran/goimp/vcs"
 )
 
-var ErrNotGoPackage = errors.New("no buildable go files")
+// Import is a dependency pinned to a revision.
+type Import = deps.Import
 
-type Import struct {
-	Package string
-	Hash    string
-}
-
-type Imports []Import
-
-func (i Imports) Len() int           { return len(i) }
-func (i Imports) Swap(m, n int)      { i[m], i[n] = i[n], i[m] }
-func (i Imports) Less(m, n int) bool { return i[m].Package < i[n].Package }
-
-type errPkgNotFound struct {
-	string
-}
-
-func (e *errPkgNotFound) Error() string {
-	path := strings.Replace(e.string, goPathSrc, "", -1)
-	path = strings.Trim(path, "/")
-	return fmt.Sprintf("'%s' not found in %s", path, goPathSrc)
-}
+// Imports sorts imports by package.
+type Imports = deps.Imports
 
 var cmdList = &Command{
-	UsageLine: "list [-r] [-p] [-hash]",
+	UsageLine: "list [-r] [-p] [-hash] [-offline] [-tags]",
 	Short:     "lists imports of the package",
 	Long: `lists imports of the package
 
 -r	lists imports recursively, do note that the dependent 
 	repositories should exist
 -p	specify the directory of the package, by default it is "."
+	or the packages of the project configuration
 -hash	prints out the commit hash of each repository
+-offline	never access the network, defaults to true when
+	GOIMP_OFFLINE is set
+-tags	comma separated build tags used to select the files parsed
+
+The project may be checked out outside GOPATH. Its import path is
+then the import setting of the project configuration, else it is taken
+from go.mod or the import comment of the package. Packages of the
+project are followed and left out of the list.
 `,
 }
 
 func init() {
 	cmdList.Run = runList // break init loop
+	addProjectFlags(&cmdList.Flag, "p", "tags")
 }
 
 var (
-	listDir       = cmdList.Flag.String("p", ".", "path of the go package")
 	listRecursive = cmdList.Flag.Bool("r", true, "recursively list imports")
 	listHash      = cmdList.Flag.Bool("hash", true, "print out the commit hash")
+	listOffline   = cmdList.Flag.Bool("offline", offlineDefault(), "forbid network access")
 )
 
 func runList(cmd *Command, args []string) {
+	vcs.Default.Offline = *listOffline
 	w := new(tabwriter.Writer)
 	w.Init(os.Stdout, 0, 8, 0, '\t', 0)
-	for _, imp := range list(*listDir, *listRecursive, *listHash) {
+	for _, imp := range list(project.Packages, *listRecursive, *listHash) {
 		fmt.Fprintf(w, "%s\t%s\n", imp.Package, imp.Hash)
 	}
 	w.Flush()
 }
 
-func list(dir string, recursive, hash bool) []Import {
-	imports, err := getPackageImports(*listDir, *listRecursive, newSet())
-	if err != nil {
-		elog.Fatal(err)
-	}
-	imports = purgeSubPackages(*listDir, imports)
-	var ret []Import
-	roots := make(map[string][]Import)
-	for _, pkg := range imports {
-		imp := Import{Package: pkg}
-		if !*listHash {
-			ret = append(ret, imp)
-			continue
-		}
-		path := filepath.Join(goPathSrc, pkg)
-		v, err := vcs.New(path, goPathSrc)
-		if err != nil {
-			continue
-		}
-		hash, err := v.CommitHash()
-		if err != nil {
-			elog.Println(err)
-			continue
-		}
-		imp.Hash = hash
-		roots[v.Root] = append(roots[v.Root], imp)
-	}
-	for root, imps := range roots {
-		if len(imps) <= 1 {
-			ret = append(ret, imps...)
-			continue
-		}
-		root = pkgPath(root) + "/..."
-		ret = append(ret, Import{Package: root, Hash: imps[0].Hash})
-	}
-	sort.Sort(Imports(ret))
-	return ret
+// list returns the imports of the packages in dirs.
+func list(dirs []string, recursive, hash bool) []Import {
+	return scan(dirs, recursive, hash).Imports
 }
 
-func purgeSubPackages(pkg string, imports []string) []string {
-	var ret []string
-	path := pkgPath(pkg)
-	for _, imp := range imports {
-		if strings.HasPrefix(imp, path) {
-			continue
-		}
-		ret = append(ret, imp)
-	}
-	return ret
+// walkImports returns the import paths reached from the packages in
+// dirs, leaving out the packages of dirs themselves.
+func walkImports(dirs []string, recursive bool) []string {
+	return scan(dirs, recursive, false).Packages
 }
 
-func pkgPath(dir string) string {
-	abs, err := filepath.Abs(dir)
-	if err != nil {
-		elog.Fatal(err)
+// resolveImports returns an Import for each of pkgs. With hash set,
+// each carries the revision checked out in its repository, packages
+// sharing a repository are grouped as "root/..." and packages missing
+// from GOPATH are dropped.
+func resolveImports(pkgs []string, hash bool) []Import {
+	g := deps.Resolve(pkgs, scanOptions(false, hash))
+	for _, err := range g.Warnings {
+		elog.Print(err)
 	}
-	return strings.Trim(abs[len(goPathSrc):], "/")
+	return g.Imports
 }
 
-func getPackageImports(dir string, recursive bool, initial *set) ([]string, error) {
-	imports := newSet()
-	files, err := parseDir(dir)
+// scan scans the packages in dirs with the settings of the project,
+// logging the warnings.
+func scan(dirs []string, recursive, hash bool) deps.Graph {
+	g, err := deps.ScanAll(dirs, scanOptions(recursive, hash))
 	if err != nil {
-		if err == ErrNotGoPackage {
-			return nil, nil
-		}
-		return nil, err
-	}
-	for _, file := range files {
-		imps := importsForFile(file)
-		imports.Extend(imps...)
-	}
-	if !recursive {
-		return imports.Export(), nil
+		elog.Fatal(err)
 	}
-
-	if goPathSrc == "" {
-		return nil, errors.New("GOPATH must be set for recursive option")
+	for _, err := range g.Warnings {
+		elog.Print(err)
 	}
+	return g
+}
 
-	for _, imp := range imports.Export() {
-		if initial != nil && initial.Contains(imp) {
-			continue
-		}
-		path := filepath.Join(goPathSrc, imp)
-		pathImports, err := getPackageImports(path, recursive,
-			newSet().Extend(imports.Export()...).Extend(initial.Export()...))
-		switch err.(type) {
-		case *errPkgNotFound:
-			elog.Print(err)
-		case nil:
-		default:
-			return nil, err
-		}
-		imports.Extend(pathImports...)
+// scanOptions returns the options of deps.ScanAll using the workspace
+// and the build tags and ignore rules of the project.
+func scanOptions(recursive, hash bool) deps.ScanOptions {
+	return deps.ScanOptions{
+		GOPATH:    goPath(),
+		Tags:      project.Tags,
+		Ignore:    project.Ignored,
+		Recursive: recursive,
+		Hash:      hash,
+		Root:      project.Root,
+		RootPath:  project.Import,
 	}
-	return imports.Export(), nil
 }
 
-// parseDir parses the package in the given directory and returns it.
-func parseDir(directory string) ([]*ast.File, error) {
-	dirFiles, err := ioutil.ReadDir(directory)
+// pkgPath returns the import path of dir. In GOPATH it is relative to
+// the entry holding dir, which may be inside the project as with the
+// GOPATH of 'goimp env'. Elsewhere in the project it is below the
+// import setting of the configuration, else as found by
+// deps.FindImportPath from go.mod or the import comment. It is the
+// absolute path of dir when none applies.
+func pkgPath(dir string) string {
+	abs, err := filepath.Abs(dir)
 	if err != nil {
-		return nil, &errPkgNotFound{directory}
-	}
-
-	var files []*ast.File
-	fs := token.NewFileSet()
-	for _, fd := range dirFiles {
-		if fd.IsDir() {
-			continue
-		}
-		name := fd.Name()
-		if !strings.HasSuffix(name, ".go") {
-			continue
-		}
-		if directory != "." {
-			name = filepath.Join(directory, name)
-		}
-		f, err := parser.ParseFile(fs, name, nil, 0)
-		if err != nil {
-			elog.Printf("ignoring unparsable file %q: %s", name, err)
-			continue
-		}
-		files = append(files, f)
+		elog.Fatal(err)
 	}
-	if len(files) == 0 {
-		return nil, ErrNotGoPackage
+	if path, ok := deps.ImportPath(goPath(), abs); ok {
+		return path
 	}
-	return files, nil
-}
-
-func importsForFile(file *ast.File) []string {
-	imports := newSet()
-	for _, imp := range file.Imports {
-		path := strings.Trim(imp.Path.Value, `"`)
-		if !isStdLib(path) {
-			imports.Add(path)
+	rel, err := filepath.Rel(projectRoot(), abs)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		if project.Import != "" {
+			return strings.TrimSuffix(project.Import+"/"+filepath.ToSlash(rel), "/.")
+		}
+		if path, ok := deps.FindImportPath(abs, goPath()); ok {
+			return path
 		}
 	}
-	return imports.Export()
+	return filepath.ToSlash(abs)
 }
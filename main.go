@@ -2,15 +2,27 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"go/build"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
+	"strconv"
+
+	"github.com/satran/goimp/deps"
 )
 
 var (
+	// goPathSrc is the src directory of the GOPATH entry new
+	// repositories are installed into, the first one by default.
 	goPathSrc string
-	depsFile  string
+	// goPathSrcs are the src directories of every GOPATH entry, in
+	// the order packages are looked up.
+	goPathSrcs []string
+	depsFile   string
+	// goPathEnv is GOPATH as given in the environment, or the default
+	// of the go tool.
+	goPathEnv string
 )
 
 var (
@@ -22,17 +34,75 @@ var commands = []*Command{
 	cmdWrite,
 	cmdGet,
 	cmdBind,
+	cmdLink,
+	cmdLicenses,
+	cmdAudit,
+	cmdSBOM,
+	cmdTidy,
+	cmdSnapshot,
+	cmdArchive,
+	cmdUnarchive,
+	cmdEnv,
+	cmdShell,
 }
 
 func init() {
 	log.SetFlags(0)
-	path := os.Getenv("GOPATH")
-	if path == "" {
-		log.Fatal("GOPATH not set")
+	// like the go tool, default to $HOME/go when GOPATH is unset;
+	// commands needing a GOPATH fail only once they use it
+	goPathEnv = build.Default.GOPATH
+	for _, entry := range filepath.SplitList(goPathEnv) {
+		if entry != "" {
+			goPathSrcs = append(goPathSrcs, filepath.Join(entry, "src"))
+		}
+	}
+	if len(goPathSrcs) > 0 {
+		goPathSrc = goPathSrcs[0]
+	}
+}
+
+// installSrc returns the src directory of the GOPATH entry new
+// repositories are installed into, exiting when there is none.
+func installSrc() string {
+	if goPathSrc == "" {
+		elog.Fatal("GOPATH not set")
 	}
-	gopath := strings.Split(path, ":")[0]
-	os.Setenv("GOPATH", gopath)
-	goPathSrc = filepath.Join(gopath, "src")
+	return goPathSrc
+}
+
+// setInstallPath makes the GOPATH entry dir the one new repositories
+// are installed into.
+func setInstallPath(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for _, src := range goPathSrcs {
+		if filepath.Dir(src) == abs {
+			goPathSrc = src
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not an entry of GOPATH %s", dir, goPathEnv)
+}
+
+// goPath returns the GOPATH entries, in the order packages are
+// looked up.
+func goPath() []string {
+	gopath := make([]string, len(goPathSrcs))
+	for i, src := range goPathSrcs {
+		gopath[i] = filepath.Dir(src)
+	}
+	return gopath
+}
+
+// srcDir returns the directory of the import path pkg in the first
+// GOPATH entry holding it, or in the install entry when none does.
+func srcDir(pkg string) string {
+	if dir, ok := deps.FindDir(goPath(), pkg); ok {
+		return dir
+	}
+	return filepath.Join(installSrc(), pkg)
 }
 
 func main() {
@@ -56,6 +126,9 @@ func main() {
 			} else {
 				cmd.Flag.Parse(args[1:])
 				args = cmd.Flag.Args()
+				if err := loadProject(&cmd.Flag); err != nil {
+					elog.Fatal(err)
+				}
 			}
 			cmd.Run(cmd, args)
 			return
@@ -63,3 +136,10 @@ func main() {
 	}
 	elog.Printf("goimp: unknown subcommand %q\nRun goimp help' for usage.\n", args[0])
 }
+
+// offlineDefault reports whether the GOIMP_OFFLINE environment
+// variable asks for offline mode. It is the default of -offline.
+func offlineDefault() bool {
+	offline, err := strconv.ParseBool(os.Getenv("GOIMP_OFFLINE"))
+	return err == nil && offline
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPkgPath(t *testing.T) {
+	defer func(srcs []string) { goPathSrcs = srcs }(goPathSrcs)
+	a, b := filepath.FromSlash("/a/src"), filepath.FromSlash("/b/src")
+	goPathSrcs = []string{a, b}
+	for dir, want := range map[string]string{
+		filepath.Join(a, "example.com/x"):   "example.com/x",
+		filepath.Join(b, "example.com/y/z"): "example.com/y/z",
+		filepath.FromSlash("/a/srcx/w"):     "/a/srcx/w",
+	} {
+		if got := pkgPath(dir); got != want {
+			t.Errorf("pkgPath(%q) = %q, want %q", dir, got, want)
+		}
+	}
+}
+
+func TestPkgPathImportSetting(t *testing.T) {
+	defer func(srcs []string) { goPathSrcs = srcs }(goPathSrcs)
+	defer func(c *Config) { project = c }(project)
+	p := filepath.FromSlash("/p")
+	// the GOPATH of 'goimp env' is inside the project
+	src := filepath.Join(p, ".goimp", "gopath", "src")
+	goPathSrcs = []string{src}
+	project = defaultConfig()
+	project.Root = p
+	project.Import = "example.org/y"
+	for dir, want := range map[string]string{
+		p:                                    "example.org/y",
+		filepath.Join(p, "sub"):              "example.org/y/sub",
+		filepath.Join(src, "github.com/a/b"): "github.com/a/b",
+		filepath.FromSlash("/q/z"):           "/q/z",
+	} {
+		if got := pkgPath(dir); got != want {
+			t.Errorf("pkgPath(%q) = %q, want %q", dir, got, want)
+		}
+	}
+}
+
+// TestProjectFlags checks that the usage line of each command lists
+// exactly the project flags it registers.
+func TestProjectFlags(t *testing.T) {
+	for _, cmd := range commands {
+		for _, name := range []string{"p", "file", "tags"} {
+			registered := cmd.Flag.Lookup(name) != nil
+			listed := strings.Contains(cmd.UsageLine, "[-"+name+"]")
+			if registered != listed {
+				t.Errorf("%s: flag -%s registered %v, in usage line %v",
+					cmd.Name(), name, registered, listed)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/satran/goimp/deps"
+)
+
+// progress reports the advancement of a set of concurrent tasks.
+// On a terminal it keeps a single status line up to date, otherwise
+// it logs a line whenever a task completes.
+type progress struct {
+	mu     sync.Mutex
+	out    *os.File
+	tty    bool
+	label  string
+	total  int
+	done   int
+	active *set
+	width  int
+}
+
+// newProgress creates a progress report for total tasks written to out.
+func newProgress(out *os.File, label string, total int) *progress {
+	return &progress{
+		out:    out,
+		tty:    isTerminal(out),
+		label:  label,
+		total:  total,
+		active: newSet(),
+	}
+}
+
+// isTerminal reports whether f is a character device.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Start marks name as running.
+func (p *progress) Start(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active.Add(name)
+	p.draw()
+}
+
+// Done marks name as complete with the given error.
+func (p *progress) Done(name string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active.Remove(name)
+	p.done++
+	if !p.tty {
+		status := "ok"
+		switch {
+		case err == deps.ErrSkipped:
+			status = "skipped"
+		case err != nil:
+			status = "failed"
+		}
+		fmt.Fprintf(p.out, "%s [%d/%d] %s %s\n",
+			p.label, p.done, p.total, name, status)
+		return
+	}
+	p.draw()
+}
+
+// Finish clears the status line.
+func (p *progress) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tty && p.width > 0 {
+		fmt.Fprintf(p.out, "\r%s\r", strings.Repeat(" ", p.width))
+		p.width = 0
+	}
+}
+
+// draw redraws the status line, the lock must be held.
+func (p *progress) draw() {
+	if !p.tty {
+		return
+	}
+	active := p.active.Export()
+	sort.Strings(active)
+	line := fmt.Sprintf("%s [%d/%d] %s",
+		p.label, p.done, p.total, strings.Join(active, ", "))
+	if len(line) > 79 {
+		line = line[:76] + "..."
+	}
+	pad := ""
+	if len(line) < p.width {
+		pad = strings.Repeat(" ", p.width-len(line))
+	}
+	fmt.Fprintf(p.out, "\r%s%s", line, pad)
+	p.width = len(line)
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/satran/goimp/vcs"
+)
+
+var cmdSBOM = &Command{
+	UsageLine: "sbom [-format] [-p] [-tags]",
+	Short:     "prints a software bill of materials",
+	Long: `prints a software bill of materials
+
+sbom describes every repository reached by 'goimp list' with its
+package URL, version control system, remote repository, revision,
+SHA-256 checksum of its content and license when one is detected.
+The checksum is that of the tracked files at the revision, as recorded
+in the manifest of 'goimp archive'.
+
+-format	document format, cyclonedx or spdx, both in JSON, defaults
+	to cyclonedx
+-p	specify the directory of the package, by default it is "."
+-tags	comma separated build tags used to select the files parsed
+
+The document is dated from SOURCE_DATE_EPOCH when it is set.
+`,
+}
+
+func init() {
+	cmdSBOM.Run = runSBOM // break init loop
+	addProjectFlags(&cmdSBOM.Flag, "p", "tags")
+}
+
+var (
+	sbomFormat = cmdSBOM.Flag.String("format", "cyclonedx", "document format, cyclonedx or spdx")
+)
+
+// component is a repository described by the bill of materials.
+type component struct {
+	Path     string
+	Revision string
+	VCS      string
+	URL      string
+	Checksum string
+	License  string
+}
+
+// PURL returns the package URL of the component.
+func (c component) PURL() string {
+	purl := "pkg:golang/" + c.Path
+	if c.Revision != "" {
+		purl += "@" + c.Revision
+	}
+	return purl
+}
+
+func runSBOM(cmd *Command, args []string) {
+	components := sbomComponents(list(project.Packages, true, true))
+	name := pkgPath(project.Dir())
+	created := sbomTime()
+
+	var doc interface{}
+	switch *sbomFormat {
+	case "cyclonedx":
+		doc = cycloneDX(name, created, components)
+	case "spdx":
+		doc = spdx(name, created, components)
+	default:
+		elog.Fatalf("unknown format %q", *sbomFormat)
+	}
+	if err := writeJSON(os.Stdout, doc); err != nil {
+		elog.Fatal(err)
+	}
+}
+
+// sbomComponents returns a component for each repository of imports.
+func sbomComponents(imports []Import) []component {
+	var components []component
+	for _, info := range scanLicenses(imports) {
+		c := component{
+			Path:     info.Repository,
+			Revision: info.Revision,
+			License:  info.License,
+		}
+		dir := srcDir(info.Repository)
+		v, err := vcs.New(dir, goPathSrcs...)
+		if err != nil {
+			elog.Print(err)
+			continue
+		}
+		c.VCS = v.Cmd()
+		if url, err := v.RemoteURL(); err == nil {
+			c.URL = url
+		}
+		if c.Revision == "" {
+			if c.Revision, err = v.CommitHash(); err != nil {
+				elog.Printf("%s: %s", c.Path, err)
+			}
+		}
+		if _, sum, err := exportFiles(v, c.Revision, nil); err == nil {
+			c.Checksum = sum
+		} else {
+			elog.Printf("%s: %s", c.Path, err)
+		}
+		components = append(components, c)
+	}
+	return components
+}
+
+// sumLines returns the hex encoded SHA-256 checksum of the sorted
+// lines, which it sorts in place.
+func sumLines(lines []string) string {
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, line := range lines {
+		io.WriteString(h, line)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// sbomTime returns the creation time of the document.
+func sbomTime() string {
+	t := time.Now()
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		t = time.Unix(epoch, 0)
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		elog.Fatal(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// knownLicense reports whether license is an identified license.
+func knownLicense(license string) bool {
+	return license != "" && license != "unknown"
+}
+
+// cycloneDX returns a CycloneDX 1.4 document for components.
+func cycloneDX(name, created string, components []component) interface{} {
+	type hash struct {
+		Alg     string `json:"alg"`
+		Content string `json:"content"`
+	}
+	type license struct {
+		License struct {
+			ID string `json:"id"`
+		} `json:"license"`
+	}
+	type reference struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}
+	type property struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	type cdxComponent struct {
+		Type               string      `json:"type"`
+		BOMRef             string      `json:"bom-ref,omitempty"`
+		Name               string      `json:"name"`
+		Version            string      `json:"version,omitempty"`
+		PURL               string      `json:"purl,omitempty"`
+		Hashes             []hash      `json:"hashes,omitempty"`
+		Licenses           []license   `json:"licenses,omitempty"`
+		ExternalReferences []reference `json:"externalReferences,omitempty"`
+		Properties         []property  `json:"properties,omitempty"`
+	}
+	type tool struct {
+		Name string `json:"name"`
+	}
+
+	var cdx []cdxComponent
+	for _, c := range components {
+		cc := cdxComponent{
+			Type:    "library",
+			BOMRef:  c.PURL(),
+			Name:    c.Path,
+			Version: c.Revision,
+			PURL:    c.PURL(),
+		}
+		if c.Checksum != "" {
+			cc.Hashes = []hash{{"SHA-256", c.Checksum}}
+		}
+		if knownLicense(c.License) {
+			var l license
+			l.License.ID = c.License
+			cc.Licenses = []license{l}
+		}
+		if c.URL != "" {
+			cc.ExternalReferences = []reference{{"vcs", c.URL}}
+		}
+		if c.VCS != "" {
+			cc.Properties = []property{{"goimp:vcs", c.VCS}}
+		}
+		cdx = append(cdx, cc)
+	}
+	type metadata struct {
+		Timestamp string       `json:"timestamp"`
+		Tools     []tool       `json:"tools"`
+		Component cdxComponent `json:"component"`
+	}
+	return struct {
+		BOMFormat    string         `json:"bomFormat"`
+		SpecVersion  string         `json:"specVersion"`
+		SerialNumber string         `json:"serialNumber"`
+		Version      int            `json:"version"`
+		Metadata     metadata       `json:"metadata"`
+		Components   []cdxComponent `json:"components"`
+	}{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.4",
+		SerialNumber: "urn:uuid:" + newUUID(),
+		Version:      1,
+		Metadata: metadata{
+			Timestamp: created,
+			Tools:     []tool{{"goimp"}},
+			Component: cdxComponent{Type: "application", Name: name},
+		},
+		Components: cdx,
+	}
+}
+
+// spdx returns an SPDX 2.3 document for components.
+func spdx(name, created string, components []component) interface{} {
+	type checksum struct {
+		Algorithm     string `json:"algorithm"`
+		ChecksumValue string `json:"checksumValue"`
+	}
+	type externalRef struct {
+		ReferenceCategory string `json:"referenceCategory"`
+		ReferenceType     string `json:"referenceType"`
+		ReferenceLocator  string `json:"referenceLocator"`
+	}
+	type pkg struct {
+		Name             string        `json:"name"`
+		SPDXID           string        `json:"SPDXID"`
+		VersionInfo      string        `json:"versionInfo,omitempty"`
+		DownloadLocation string        `json:"downloadLocation"`
+		FilesAnalyzed    bool          `json:"filesAnalyzed"`
+		Checksums        []checksum    `json:"checksums,omitempty"`
+		LicenseConcluded string        `json:"licenseConcluded"`
+		LicenseDeclared  string        `json:"licenseDeclared"`
+		CopyrightText    string        `json:"copyrightText"`
+		ExternalRefs     []externalRef `json:"externalRefs,omitempty"`
+	}
+	type relationship struct {
+		SPDXElementID      string `json:"spdxElementId"`
+		RelationshipType   string `json:"relationshipType"`
+		RelatedSPDXElement string `json:"relatedSpdxElement"`
+	}
+
+	root := pkg{
+		Name:             name,
+		SPDXID:           "SPDXRef-Package-root",
+		DownloadLocation: "NOASSERTION",
+		LicenseConcluded: "NOASSERTION",
+		LicenseDeclared:  "NOASSERTION",
+		CopyrightText:    "NOASSERTION",
+	}
+	packages := []pkg{root}
+	relationships := []relationship{{"SPDXRef-DOCUMENT", "DESCRIBES", root.SPDXID}}
+	for i, c := range components {
+		p := pkg{
+			Name:             c.Path,
+			SPDXID:           fmt.Sprintf("SPDXRef-Package-%d", i+1),
+			VersionInfo:      c.Revision,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+			ExternalRefs:     []externalRef{{"PACKAGE-MANAGER", "purl", c.PURL()}},
+		}
+		if c.URL != "" && c.VCS != "" {
+			p.DownloadLocation = spdxDownloadLocation(c)
+		}
+		if c.Checksum != "" {
+			p.Checksums = []checksum{{"SHA256", c.Checksum}}
+		}
+		if knownLicense(c.License) {
+			p.LicenseDeclared = c.License
+		}
+		packages = append(packages, p)
+		relationships = append(relationships,
+			relationship{root.SPDXID, "DEPENDS_ON", p.SPDXID})
+	}
+
+	type creationInfo struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	}
+	return struct {
+		SPDXVersion       string         `json:"spdxVersion"`
+		DataLicense       string         `json:"dataLicense"`
+		SPDXID            string         `json:"SPDXID"`
+		Name              string         `json:"name"`
+		DocumentNamespace string         `json:"documentNamespace"`
+		CreationInfo      creationInfo   `json:"creationInfo"`
+		Packages          []pkg          `json:"packages"`
+		Relationships     []relationship `json:"relationships"`
+	}{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: "https://spdx.org/spdxdocs/goimp/" + newUUID(),
+		CreationInfo:      creationInfo{created, []string{"Tool: goimp"}},
+		Packages:          packages,
+		Relationships:     relationships,
+	}
+}
+
+// spdxDownloadLocation returns the VCS location of c in the form
+// SPDX expects, such as git+https://host/repo@revision.
+func spdxDownloadLocation(c component) string {
+	url := c.URL
+	if !strings.Contains(url, "://") {
+		// scp-like syntax, user@host:path
+		if strings.Contains(url, ":") {
+			url = "ssh://" + strings.Replace(url, ":", "/", 1)
+		}
+	}
+	loc := c.VCS + "+" + url
+	if c.Revision != "" {
+		loc += "@" + c.Revision
+	}
+	return loc
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/satran/goimp/vcs"
+)
+
+func TestSPDXDownloadLocation(t *testing.T) {
+	for url, want := range map[string]string{
+		"https://github.com/satran/edi.git": "git+https://github.com/satran/edi.git@abc",
+		"git@github.com:satran/edi.git":     "git+ssh://git@github.com/satran/edi.git@abc",
+	} {
+		c := component{Path: "github.com/satran/edi", Revision: "abc", VCS: "git", URL: url}
+		if got := spdxDownloadLocation(c); got != want {
+			t.Errorf("spdxDownloadLocation(%q) = %q, want %q", url, got, want)
+		}
+	}
+}
+
+func TestExportChecksum(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "goimp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := filepath.Join(dir, "src", "lib")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repo, "lib.go"), []byte("package lib\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("lib.go", filepath.Join(repo, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+	var rev string
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"commit", "-q", "-m", "init"},
+		{"rev-parse", "HEAD"},
+	} {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+		}
+		rev = strings.TrimSpace(string(out))
+	}
+	v, err := vcs.New(repo, filepath.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sum, err := exportFiles(v, rev, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// untracked files are not part of the revision
+	if err := ioutil.WriteFile(filepath.Join(repo, "untracked.go"), []byte("package lib\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	spool, err := ioutil.TempFile(dir, "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	files, archived, err := exportFiles(v, rev, spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived != sum {
+		t.Errorf("archive checksum %s, sbom checksum %s", archived, sum)
+	}
+	if len(files) != 2 {
+		t.Errorf("exported %d files, want 2", len(files))
+	}
+}
@@ -38,3 +38,8 @@ func (s *set) Extend(elems ...string) *set {
 	}
 	return s
 }
+
+// Remove deletes an element from the set
+func (s *set) Remove(elem string) {
+	delete(s.state, elem)
+}
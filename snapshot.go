@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/satran/goimp/vcs"
+)
+
+var cmdSnapshot = &Command{
+	UsageLine: "snapshot [-p] [-tags] save|restore|delete name",
+	Short:     "saves and restores the revisions of the workspace",
+	Long: `saves and restores the revisions of the workspace
+
+snapshot save records the revision checked out in every repository
+reached by 'goimp list', along with whether it had uncommitted
+changes. snapshot restore checks those revisions back out, which
+gives a safety net before trying newer dependencies with get -reset.
+snapshot delete removes a snapshot. Without arguments the snapshots
+are listed.
+
+Uncommitted changes are not part of a snapshot: restore warns about
+the repositories that had some when saved, and leaves alone those
+that have some now. Linked packages are left out.
+
+Snapshots are stored in .goimp/snapshots under the project root.
+
+-p	specify the directory of the package, by default it is "."
+	or the packages of the project configuration
+-tags	comma separated build tags used to select the files parsed
+`,
+}
+
+func init() {
+	cmdSnapshot.Run = runSnapshot // break init loop
+	addProjectFlags(&cmdSnapshot.Flag, "p", "tags")
+}
+
+// snapshotEntry is the state of a repository in a snapshot.
+type snapshotEntry struct {
+	Repository string
+	Revision   string
+	Dirty      bool
+}
+
+func runSnapshot(cmd *Command, args []string) {
+	if len(args) == 0 {
+		names, err := snapshotNames()
+		if err != nil {
+			elog.Fatal(err)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return
+	}
+	if len(args) != 2 {
+		cmd.Usage()
+	}
+	name := args[1]
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		elog.Fatalf("invalid snapshot name %q", name)
+	}
+	switch args[0] {
+	case "save":
+		entries, err := takeSnapshot(list(project.Packages, true, false))
+		if err != nil {
+			elog.Fatal(err)
+		}
+		if err := writeSnapshot(name, entries); err != nil {
+			elog.Fatal(err)
+		}
+		for _, e := range entries {
+			if e.Dirty {
+				elog.Printf("warning: %s has uncommitted changes, they are not saved", e.Repository)
+			}
+		}
+	case "restore":
+		entries, err := readSnapshot(name)
+		if err != nil {
+			elog.Fatal(err)
+		}
+		if !restoreSnapshot(entries) {
+			os.Exit(1)
+		}
+	case "delete":
+		if err := os.Remove(snapshotFile(name)); err != nil {
+			elog.Fatal(err)
+		}
+	default:
+		cmd.Usage()
+	}
+}
+
+// snapshotFile returns the path of the snapshot name.
+func snapshotFile(name string) string {
+	return filepath.Join(project.StateDir(), "snapshots", name)
+}
+
+// snapshotNames returns the names of the saved snapshots.
+func snapshotNames() ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(project.StateDir(), "snapshots"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range files {
+		if !fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") {
+			names = append(names, fi.Name())
+		}
+	}
+	return names, nil
+}
+
+// takeSnapshot returns the state of the repositories of imports,
+// sorted by repository.
+func takeSnapshot(imports []Import) ([]snapshotEntry, error) {
+	links, err := readLinks()
+	if err != nil {
+		return nil, err
+	}
+	seen := newSet()
+	var entries []snapshotEntry
+	for _, imp := range imports {
+		if _, ok := linkedDir(links, imp.Package); ok {
+			continue
+		}
+		v, err := vcs.New(importDir(imp), goPathSrcs...)
+		if err != nil {
+			elog.Print(err)
+			continue
+		}
+		if seen.Contains(v.Root) {
+			continue
+		}
+		seen.Add(v.Root)
+		e := snapshotEntry{Repository: pkgPath(v.Root)}
+		if e.Revision, err = v.CommitHash(); err != nil {
+			return nil, fmt.Errorf("%s: %s", e.Repository, err)
+		}
+		if e.Dirty, err = v.Dirty(); err != nil {
+			return nil, fmt.Errorf("%s: %s", e.Repository, err)
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Repository < entries[j].Repository
+	})
+	return entries, nil
+}
+
+// writeSnapshot records entries as the snapshot name, one tab
+// separated "repository revision state" line each.
+func writeSnapshot(name string, entries []snapshotEntry) error {
+	path := snapshotFile(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	var content []byte
+	for _, e := range entries {
+		state := "clean"
+		if e.Dirty {
+			state = "dirty"
+		}
+		content = append(content, e.Repository+"\t"+e.Revision+"\t"+state+"\n"...)
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// readSnapshot returns the entries of the snapshot name.
+func readSnapshot(name string) ([]snapshotEntry, error) {
+	f, err := os.Open(snapshotFile(name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no snapshot named %q", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []snapshotEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		entries = append(entries, snapshotEntry{fields[0], fields[1], fields[2] == "dirty"})
+	}
+	return entries, scanner.Err()
+}
+
+// restoreSnapshot checks out the revisions of entries, reporting the
+// outcome of each repository. It returns false if any of them could
+// not be restored.
+func restoreSnapshot(entries []snapshotEntry) bool {
+	ok := true
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, ' ', 0)
+	for _, e := range entries {
+		status := "ok"
+		if err := restoreEntry(e); err != nil {
+			status = "failed: " + err.Error()
+			ok = false
+		} else if e.Dirty {
+			status = "ok, uncommitted changes at save time were not restored"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.Repository, e.Revision, status)
+	}
+	w.Flush()
+	return ok
+}
+
+func restoreEntry(e snapshotEntry) error {
+	v, err := vcs.New(srcDir(e.Repository), goPathSrcs...)
+	if err != nil {
+		return err
+	}
+	dirty, err := v.Dirty()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("repository has uncommitted changes")
+	}
+	return v.Checkout(e.Revision)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goimp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(root string) { project.Root = root }(project.Root)
+	project.Root = dir
+
+	entries := []snapshotEntry{
+		{"github.com/a/lib", "abc", false},
+		{"github.com/b/lib", "def", true},
+	}
+	if err := writeSnapshot("before", entries); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readSnapshot("before")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("readSnapshot = %v, want %v", got, entries)
+	}
+	if names, _ := snapshotNames(); !reflect.DeepEqual(names, []string{"before"}) {
+		t.Errorf("snapshotNames = %v, want [before]", names)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/satran/goimp/deps"
+	"github.com/satran/goimp/vcs"
+)
+
+var cmdTidy = &Command{
+	UsageLine: "tidy [-w] [-p] [-file] [-tags]",
+	Short:     "reports unused and missing dependencies",
+	Long: `reports unused and missing dependencies
+
+tidy compares the entries of the Godeps file with the imports reached
+from the packages, as 'goimp list' walks them. An entry no import falls
+under is unused, an import no entry covers is missing.
+
+-w	rewrites the file, dropping unused entries and adding missing
+	ones. Retained entries keep their pinned revision; new entries
+	take the revision pinned for their repository, else the one
+	checked out, else none
+-p	specify the directory of the package, by default it is "."
+	or the packages of the project configuration
+-file	file to compare, defaults to Godeps
+-tags	comma separated build tags used to select the files parsed
+
+Without -w the exit status is non-zero when the file is not tidy.
+`,
+}
+
+func init() {
+	cmdTidy.Run = runTidy // break init loop
+	addProjectFlags(&cmdTidy.Flag, "p", "file", "tags")
+}
+
+var (
+	tidyWrite = cmdTidy.Flag.Bool("w", false, "rewrite the deps file")
+)
+
+func runTidy(cmd *Command, args []string) {
+	if *tidyWrite {
+		unlock, err := lockDeps()
+		if err != nil {
+			elog.Fatal(err)
+		}
+		defer unlock()
+	}
+	entries := getImportsFromFile(project.DepsFile())
+	unused, missing := tidyDiff(entries, walkImports(project.Packages, true))
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, ' ', 0)
+	for _, imp := range unused {
+		fmt.Fprintf(w, "unused\t%s\t%s\n", imp.Package, imp.Hash)
+	}
+	for _, pkg := range missing {
+		fmt.Fprintf(w, "missing\t%s\t\n", pkg)
+	}
+	w.Flush()
+
+	if !*tidyWrite {
+		if len(unused) > 0 || len(missing) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if len(unused) == 0 && len(missing) == 0 {
+		return
+	}
+	if err := deps.WriteFile(project.DepsFile(), tidyImports(entries, unused, missing, true)); err != nil {
+		elog.Fatal(err)
+	}
+}
+
+// covers reports whether the deps file entry applies to the package
+// pkg, either naming it or being a "root/..." entry above it.
+func covers(entry, pkg string) bool {
+	if entry == pkg {
+		return true
+	}
+	if root := strings.TrimSuffix(entry, "/..."); root != entry {
+		return pkg == root || strings.HasPrefix(pkg, root+"/")
+	}
+	return false
+}
+
+// tidyDiff returns the entries covering none of pkgs and the sorted
+// pkgs no entry covers.
+func tidyDiff(entries []Import, pkgs []string) (unused []Import, missing []string) {
+	used := make([]bool, len(entries))
+	for _, pkg := range pkgs {
+		found := false
+		for i, entry := range entries {
+			if covers(entry.Package, pkg) {
+				used[i] = true
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, pkg)
+		}
+	}
+	for i, entry := range entries {
+		if !used[i] {
+			unused = append(unused, entry)
+		}
+	}
+	sort.Strings(missing)
+	return unused, missing
+}
+
+// tidyImports returns entries without unused, plus entries for the
+// missing packages. With hash set, a new entry of a repository already
+// pinned by a retained entry takes its revision rather than the one
+// checked out.
+func tidyImports(entries, unused []Import, missing []string, hash bool) []Import {
+	drop := newSet()
+	for _, imp := range unused {
+		drop.Add(imp.Package)
+	}
+	pins := make(map[string]string)
+	var ret []Import
+	for _, imp := range entries {
+		if drop.Contains(imp.Package) {
+			continue
+		}
+		ret = append(ret, imp)
+		if root := repoRoot(imp.Package); root != "" && imp.Hash != "" {
+			pins[root] = imp.Hash
+		}
+	}
+
+	for _, imp := range resolveImports(missing, hash) {
+		if pin, ok := pins[repoRoot(imp.Package)]; ok && hash {
+			imp.Hash = pin
+		}
+		ret = append(ret, imp)
+	}
+	for _, pkg := range missing {
+		found := false
+		for _, imp := range ret {
+			if covers(imp.Package, pkg) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			// not in GOPATH, left for get to fetch at the latest revision
+			ret = append(ret, Import{Package: pkg})
+		}
+	}
+	sort.Sort(Imports(ret))
+	return ret
+}
+
+// repoRoot returns the import path of the root of the repository
+// holding pkg in GOPATH, or "" when there is none.
+func repoRoot(pkg string) string {
+	v, err := vcs.New(importDir(Import{Package: pkg}), goPathSrcs...)
+	if err != nil {
+		return ""
+	}
+	return pkgPath(v.Root)
+}
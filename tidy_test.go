@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTidyDiff(t *testing.T) {
+	entries := []Import{
+		{Package: "github.com/a/lib/...", Hash: "abc"},
+		{Package: "github.com/b/old", Hash: "def"},
+		{Package: "github.com/c/pkg", Hash: "123"},
+	}
+	pkgs := []string{
+		"github.com/a/lib",
+		"github.com/a/lib/sub",
+		"github.com/c/pkg",
+		"github.com/c/pkg/sub",
+		"github.com/d/new",
+	}
+	unused, missing := tidyDiff(entries, pkgs)
+	if want := []Import{{Package: "github.com/b/old", Hash: "def"}}; !reflect.DeepEqual(unused, want) {
+		t.Errorf("unused = %v, want %v", unused, want)
+	}
+	if want := []string{"github.com/c/pkg/sub", "github.com/d/new"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("missing = %v, want %v", missing, want)
+	}
+}
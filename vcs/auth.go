@@ -0,0 +1,243 @@
+package vcs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HostAuth holds the settings used to reach the repositories of a host.
+type HostAuth struct {
+	// Rewrite replaces "https://host/" in repository URLs. The value
+	// "ssh" stands for "git@host:".
+	Rewrite string
+	// Helper is a git credential helper queried for the host.
+	Helper string
+	// Netrc enables the credentials of the host in the netrc file.
+	Netrc bool
+}
+
+// RewriteURL applies the rewrite rule of the host of url.
+func (c *Config) RewriteURL(url string) string {
+	for host, a := range c.Auth {
+		prefix := "https://" + host + "/"
+		if a.Rewrite != "" && strings.HasPrefix(url, prefix) {
+			return rewritePrefix(host, a.Rewrite) + url[len(prefix):]
+		}
+	}
+	return url
+}
+
+func rewritePrefix(host, rewrite string) string {
+	if rewrite == "ssh" {
+		return "git@" + host + ":"
+	}
+	return rewrite
+}
+
+// load builds the environment of commands and the secrets it holds
+// on first use.
+func (c *Config) load() {
+	c.once.Do(func() {
+		c.env = c.buildEnv()
+	})
+}
+
+// Env returns the environment for commands, such as the go tool,
+// that may run version control commands themselves. The result may be
+// appended to but not modified.
+func (c *Config) Env() []string {
+	c.load()
+	return c.env[:len(c.env):len(c.env)]
+}
+
+// buildEnv returns the environment of version control commands.
+// Rewrite rules and credential helpers are passed to git, including
+// when run by the go tool, through GIT_CONFIG_* variables so that they
+// are scoped to their host and never appear on a command line. Netrc
+// credentials are only given in variables read by the helper.
+func (c *Config) buildEnv() []string {
+	env := os.Environ()
+	if len(c.Auth) == 0 {
+		return env
+	}
+	hosts := make([]string, 0, len(c.Auth))
+	for host := range c.Auth {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	n, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	config := func(key, value string) {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", n, key),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", n, value))
+		n++
+	}
+	var netrc string
+	if c.needsNetrc() {
+		netrc = c.readNetrc()
+	}
+	for i, host := range hosts {
+		a := c.Auth[host]
+		url := "https://" + host
+		if a.Rewrite != "" {
+			config("url."+rewritePrefix(host, a.Rewrite)+".insteadOf", url+"/")
+		}
+		if a.Helper != "" {
+			config("credential."+url+".helper", a.Helper)
+		}
+		if a.Netrc {
+			login, password, ok := parseNetrc(netrc, host)
+			if !ok {
+				continue
+			}
+			user := fmt.Sprintf("GOIMP_CREDENTIAL_%d_USERNAME", i)
+			pass := fmt.Sprintf("GOIMP_CREDENTIAL_%d_PASSWORD", i)
+			env = append(env, user+"="+login, pass+"="+password)
+			config("credential."+url+".helper", fmt.Sprintf(
+				`!f() { test "$1" = get && echo username="$%s" && echo password="$%s"; }; f`,
+				user, pass))
+			if password != "" {
+				c.secrets = append(c.secrets, password)
+			}
+		}
+	}
+	env = append(env, "GIT_CONFIG_COUNT="+strconv.Itoa(n), "GIT_TERMINAL_PROMPT=0")
+	return env
+}
+
+// userinfo matches the credentials embedded in a URL.
+var userinfo = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/@\s]+@`)
+
+// redact removes credentials from the output of a command.
+func (c *Config) redact(s string) string {
+	c.load()
+	s = userinfo.ReplaceAllString(s, "${1}***@")
+	for _, secret := range c.secrets {
+		s = strings.Replace(s, secret, "***", -1)
+	}
+	return s
+}
+
+// Redact returns a writer passing the output of a command to w with
+// the credentials removed. Output is written a line at a time, the
+// last incomplete line when the writer is closed.
+func (c *Config) Redact(w io.Writer) io.WriteCloser {
+	return &redactWriter{c: c, w: w}
+}
+
+type redactWriter struct {
+	c   *Config
+	w   io.Writer
+	buf []byte
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+	i := bytes.LastIndexByte(r.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	if _, err := io.WriteString(r.w, r.c.redact(string(r.buf[:i+1]))); err != nil {
+		return 0, err
+	}
+	r.buf = append(r.buf[:0], r.buf[i+1:]...)
+	return len(p), nil
+}
+
+func (r *redactWriter) Close() error {
+	if len(r.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(r.w, r.c.redact(string(r.buf)))
+	r.buf = nil
+	return err
+}
+
+// needsNetrc reports whether a host takes its credentials from the
+// netrc file.
+func (c *Config) needsNetrc() bool {
+	for _, a := range c.Auth {
+		if a.Netrc {
+			return true
+		}
+	}
+	return false
+}
+
+// readNetrc returns the content of the netrc file, empty when it
+// cannot be read.
+func (c *Config) readNetrc() string {
+	path := c.NetrcFile
+	if path == "" {
+		path = os.Getenv("NETRC")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
+
+// parseNetrc returns the credentials of host in the netrc content,
+// falling back to the default entry.
+func parseNetrc(content, host string) (login, password string, ok bool) {
+	var defLogin, defPassword string
+	var hasDefault bool
+	fields := strings.Fields(content)
+	machine := ""
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "machine":
+			if i+1 < len(fields) {
+				i++
+				machine = fields[i]
+			}
+		case "default":
+			machine = ""
+			hasDefault = true
+		case "login", "password", "account":
+			if i+1 >= len(fields) {
+				break
+			}
+			i++
+			value := fields[i]
+			switch {
+			case machine == host && fields[i-1] == "login":
+				login, ok = value, true
+			case machine == host && fields[i-1] == "password":
+				password, ok = value, true
+			case machine == "" && hasDefault && fields[i-1] == "login":
+				defLogin = value
+			case machine == "" && hasDefault && fields[i-1] == "password":
+				defPassword = value
+			}
+		case "macdef":
+			// macros run until an empty line, which Fields loses;
+			// stop as nothing after them can be trusted.
+			i = len(fields)
+		}
+	}
+	if ok {
+		return login, password, true
+	}
+	if hasDefault && (defLogin != "" || defPassword != "") {
+		return defLogin, defPassword, true
+	}
+	return "", "", false
+}
@@ -0,0 +1,128 @@
+package vcs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorClass categorises the failure of a version control command.
+type ErrorClass int
+
+const (
+	// ClassOther is any failure that could not be classified.
+	ClassOther ErrorClass = iota
+	// ClassNetwork is a transient failure reaching the remote.
+	ClassNetwork
+	// ClassAuth is a failure to authenticate with the remote.
+	ClassAuth
+	// ClassNotFound is a missing repository or revision.
+	ClassNotFound
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ClassNetwork:
+		return "network"
+	case ClassAuth:
+		return "authentication"
+	case ClassNotFound:
+		return "not found"
+	}
+	return "other"
+}
+
+// Transient reports whether a command failing with this class
+// may succeed when run again.
+func (c ErrorClass) Transient() bool {
+	return c == ClassNetwork
+}
+
+// Error is the failure of a version control command, carrying
+// its standard error output.
+type Error struct {
+	Class    ErrorClass
+	Stderr   string
+	Attempts int
+}
+
+func (e *Error) Error() string {
+	msg := strings.TrimSpace(e.Stderr)
+	if e.Class == ClassOther {
+		return msg
+	}
+	if e.Attempts > 1 {
+		return fmt.Sprintf("%s error after %d attempts: %s",
+			e.Class, e.Attempts, msg)
+	}
+	return fmt.Sprintf("%s error: %s", e.Class, msg)
+}
+
+// classPatterns maps lower cased fragments of the standard error of
+// git, hg, bzr and go get to the class of failure they denote. The classes
+// are checked in order.
+var classPatterns = []struct {
+	class    ErrorClass
+	patterns []string
+}{
+	{ClassAuth, []string{
+		"authentication failed",
+		"authorization failed",
+		"permission denied",
+		"could not read username",
+		"could not read password",
+		"terminal prompts disabled",
+		"http error 401",
+		"http error 403",
+		"returned error: 401",
+		"returned error: 403",
+	}},
+	{ClassNotFound, []string{
+		"repository not found",
+		"does not appear to be a git repository",
+		"unknown revision",
+		"did not match any file(s) known to git",
+		"reference is not a tree",
+		"not a valid object name",
+		"couldn't find remote ref",
+		"http error 404",
+		"returned error: 404",
+		"no such revision",
+		"cannot find package",
+	}},
+	{ClassNetwork, []string{
+		"could not resolve host",
+		"temporary failure in name resolution",
+		"connection timed out",
+		"operation timed out",
+		"connection refused",
+		"connection reset",
+		"network is unreachable",
+		"no route to host",
+		"no such host",
+		"i/o timeout",
+		"the remote end hung up unexpectedly",
+		"early eof",
+		"tls handshake timeout",
+		"ssl_read",
+		"gnutls_handshake",
+		"returned error: 502",
+		"returned error: 503",
+		"returned error: 504",
+		"http error 502",
+		"http error 503",
+		"http error 504",
+	}},
+}
+
+// classify returns the class of failure described by stderr.
+func classify(stderr string) ErrorClass {
+	lower := strings.ToLower(stderr)
+	for _, c := range classPatterns {
+		for _, p := range c.patterns {
+			if strings.Contains(lower, p) {
+				return c.class
+			}
+		}
+	}
+	return ClassOther
+}
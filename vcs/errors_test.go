@@ -0,0 +1,19 @@
+package vcs
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	for stderr, class := range map[string]ErrorClass{
+		"fatal: unable to access 'https://example.com/r.git/': Could not resolve host: example.com": ClassNetwork,
+		"fatal: Authentication failed for 'https://example.com/r.git/'":                             ClassAuth,
+		"ERROR: Repository not found.":                                 ClassNotFound,
+		"error: pathspec 'abc' did not match any file(s) known to git": ClassNotFound,
+		"fatal: index.lock exists":                                     ClassOther,
+		"package example.com/x: unrecognized import path \"example.com/x\": https fetch: Get \"https://example.com/x?go-get=1\": dial tcp: lookup example.com: no such host": ClassNetwork,
+		"cannot find package \"example.com/lib/x\" in any of:": ClassNotFound,
+	} {
+		if got := classify(stderr); got != class {
+			t.Errorf("classify(%q) = %s, want %s", stderr, got, class)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package vcs
+
+import "os/exec"
+
+// setProcessGroup does nothing, process groups are not supported.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the started cmd, leaving the commands it
+// started running.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,27 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package vcs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExecuteKillsChildren(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	// the sleep started by sh keeps the standard error open, Execute
+	// only returns early when it is killed too.
+	var stderr bytes.Buffer
+	start := time.Now()
+	err := NewConfig().Execute(ctx, "", nil, nil, &stderr, "sh", "-c", "sleep 30; true")
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Execute = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("Execute returned after %s", d)
+	}
+}
@@ -1,14 +1,58 @@
 package vcs
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
+// ErrOffline is returned by network operations when Config.Offline
+// is set.
+var ErrOffline = errors.New("network access disabled in offline mode")
+
+// Config holds the settings of version control commands. A Config
+// must not be changed once a command has used it, as Auth and
+// NetrcFile are read only once.
+type Config struct {
+	// Offline forbids any operation that needs to contact a remote
+	// repository. Such operations return ErrOffline instead.
+	Offline bool
+	// Retries is the number of times a command failing with a
+	// transient error is run again. RetryDelay is the wait before the
+	// first retry, doubled before each further one.
+	Retries    int
+	RetryDelay time.Duration
+	// Auth maps host names to their settings.
+	Auth map[string]HostAuth
+	// NetrcFile is the netrc file read for credentials, defaulting to
+	// $NETRC or ~/.netrc.
+	NetrcFile string
+
+	once    sync.Once
+	env     []string
+	secrets []string
+}
+
+// NewConfig returns a Config with the default retry settings.
+func NewConfig() *Config {
+	return &Config{
+		Retries:    2,
+		RetryDelay: time.Second,
+		Auth:       make(map[string]HostAuth),
+	}
+}
+
+// Default is the Config of repositories not given one by WithConfig.
+var Default = NewConfig()
+
 type VCS struct {
 	// Root is the import path corresponding to the root of the repository
 	Root string
@@ -19,6 +63,15 @@ type VCS struct {
 	checkout string
 	fetch    string
 	pull     string
+	exists   string
+	head     string
+	status   string
+	unpushed string
+	stash    string
+	clone    string
+
+	ctx  context.Context
+	conf *Config
 }
 
 var vcsList = []VCS{
@@ -29,6 +82,12 @@ var vcsList = []VCS{
 		checkout: "checkout",
 		fetch:    "fetch",
 		pull:     "pull",
+		exists:   "cat-file -e",
+		head:     ".git/HEAD",
+		status:   "status --porcelain --untracked-files=no",
+		unpushed: "rev-list -n 1 --branches --not --remotes",
+		stash:    "stash",
+		clone:    "clone",
 	},
 	{
 		name:     "Mercurial",
@@ -37,6 +96,12 @@ var vcsList = []VCS{
 		checkout: "update",
 		fetch:    "pull",
 		pull:     "pull -u",
+		exists:   "log -q -r",
+		head:     ".hg/dirstate",
+		status:   "status -mard",
+		unpushed: "log -r draft() -T {node}",
+		stash:    "--config extensions.shelve= shelve",
+		clone:    "clone",
 	},
 	{
 		name:     "Bazaar",
@@ -44,18 +109,32 @@ var vcsList = []VCS{
 		commit:   "revno",
 		checkout: "revert -r",
 		fetch:    "pull --overwrite",
+		exists:   "log -q -r",
+		head:     ".bzr/checkout/dirstate",
+		status:   "status -S -V",
+		stash:    "shelve --all",
+		clone:    "branch",
 	},
 }
 
 // New inspects dir and its parents to determine the
-// version control system and code repository to use.
-func New(dir, parent string) (vcs *VCS, err error) {
+// version control system and code repository to use. The search
+// stops at the first of roots containing dir.
+func New(dir string, roots ...string) (vcs *VCS, err error) {
 	// Clean and double-check that dir is in (a subdirectory of) srcRoot.
 	dir = filepath.Clean(dir)
-	srcRoot := filepath.Clean(parent)
-	if len(dir) <= len(srcRoot) || dir[len(srcRoot)] != filepath.Separator {
+	srcRoot := ""
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if len(dir) > len(root) && dir[len(root)] == filepath.Separator &&
+			strings.HasPrefix(dir, root) {
+			srcRoot = root
+			break
+		}
+	}
+	if srcRoot == "" {
 		return nil, fmt.Errorf(
-			"directory %q is outside source root %q", dir, srcRoot)
+			"directory %q is outside source roots %q", dir, roots)
 	}
 
 	origDir := dir
@@ -81,20 +160,254 @@ func New(dir, parent string) (vcs *VCS, err error) {
 	return nil, fmt.Errorf("directory %q is not using a known version control system", origDir)
 }
 
+// Clone clones the repository at url into dir. The version control
+// system is given by a "hg+" or "bzr+" prefix of url, defaulting to
+// git.
+func (c *Config) Clone(ctx context.Context, url, dir string) error {
+	v := vcsList[0]
+	for _, vcs := range vcsList {
+		if strings.HasPrefix(url, vcs.cmd+"+") {
+			v = vcs
+			url = url[len(vcs.cmd)+1:]
+			break
+		}
+	}
+	if c.Offline {
+		return ErrOffline
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	url = c.RewriteURL(url)
+	args := strings.Split(v.clone, " ")
+	args = append(args, url, dir)
+	return c.execute(ctx, "", v.cmd, args...)
+}
+
+// WithContext returns a copy of v whose commands are bound to ctx.
+// Cancelling ctx kills any running command.
+func (v *VCS) WithContext(ctx context.Context) *VCS {
+	c := *v
+	c.ctx = ctx
+	return &c
+}
+
+func (v *VCS) context() context.Context {
+	if v.ctx == nil {
+		return context.Background()
+	}
+	return v.ctx
+}
+
+// WithConfig returns a copy of v whose commands use the settings of c
+// instead of Default.
+func (v *VCS) WithConfig(c *Config) *VCS {
+	r := *v
+	r.conf = c
+	return &r
+}
+
+func (v *VCS) config() *Config {
+	if v.conf == nil {
+		return Default
+	}
+	return v.conf
+}
+
+// Name returns the name of the version control system.
+func (v *VCS) Name() string {
+	return v.name
+}
+
+// Cmd returns the command of the version control system, which is
+// also its common short name.
+func (v *VCS) Cmd() string {
+	return v.cmd
+}
+
+// RemoteURL returns the URL of the default remote of the repository,
+// without any credentials it embeds.
+func (v *VCS) RemoteURL() (string, error) {
+	var args []string
+	switch v.cmd {
+	case "git":
+		args = []string{"config", "--get", "remote.origin.url"}
+	case "hg":
+		args = []string{"paths", "default"}
+	case "bzr":
+		args = []string{"config", "parent_location"}
+	}
+	output, err := v.output(args...)
+	if err != nil {
+		return "", err
+	}
+	return v.config().redact(strings.TrimSpace(output)), nil
+}
+
+// HeadFile returns the path of the file that changes whenever a
+// different revision is checked out in the repository.
+func (v *VCS) HeadFile() string {
+	return filepath.Join(v.Root, v.head)
+}
+
 // CommitHash provides the latest commit hash for a given directory.
 // If a gopath is provided it limits the search inside the path.
 func (v *VCS) CommitHash() (string, error) {
 	if v.commit == "" {
 		return "", fmt.Errorf("%s is not yet supported", v.name)
 	}
-	args := strings.Split(v.commit, " ")
-	cmd := exec.Command(v.cmd, args...)
+	output, err := v.output(strings.Split(v.commit, " ")...)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(output, "\n"), nil
+}
+
+// IsAncestor reports whether the revision ancestor is rev or one of
+// its ancestors.
+func (v *VCS) IsAncestor(ancestor, rev string) (bool, error) {
+	var args []string
+	switch v.cmd {
+	case "git":
+		args = []string{"merge-base", "--is-ancestor", ancestor, rev}
+	case "hg":
+		args = []string{"log", "-r", fmt.Sprintf("ancestor(%s, %s) and %s", ancestor, rev, ancestor), "-T", "x"}
+	default:
+		return false, fmt.Errorf("%s is not yet supported", v.name)
+	}
+	output, err := v.output(args...)
+	if v.cmd == "git" {
+		if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == 1 {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	return output != "", nil
+}
+
+// NearestTag returns the closest tag reachable from rev.
+func (v *VCS) NearestTag(rev string) (string, error) {
+	var args []string
+	switch v.cmd {
+	case "git":
+		args = []string{"describe", "--tags", "--abbrev=0", rev}
+	case "hg":
+		args = []string{"log", "-r", rev, "-T", "{latesttag}"}
+	default:
+		return "", fmt.Errorf("%s is not yet supported", v.name)
+	}
+	output, err := v.output(args...)
+	if err != nil {
+		return "", err
+	}
+	tag := strings.TrimSpace(output)
+	if tag == "" || tag == "null" {
+		return "", fmt.Errorf("no tag reachable from %s", rev)
+	}
+	return tag, nil
+}
+
+// Dirty reports whether the working tree has uncommitted changes
+// to tracked files.
+func (v *VCS) Dirty() (bool, error) {
+	if v.status == "" {
+		return false, fmt.Errorf("%s is not yet supported", v.name)
+	}
+	output, err := v.output(strings.Split(v.status, " ")...)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(output) != "", nil
+}
+
+// Status is the local work in a repository that a checkout could
+// lose or hide.
+type Status struct {
+	// Modified is set when tracked files have uncommitted changes.
+	Modified bool
+	// Unpushed is set when commits are missing from the remotes. It
+	// is only detected for git and Mercurial repositories having a
+	// default remote. For git only local branches are checked, a
+	// detached head may be at a tag or at a revision of a fork.
+	Unpushed bool
+}
+
+// Status returns the local work in the repository.
+func (v *VCS) Status() (Status, error) {
+	var s Status
+	var err error
+	if s.Modified, err = v.Dirty(); err != nil {
+		return s, err
+	}
+	if v.unpushed == "" {
+		return s, nil
+	}
+	if _, err := v.RemoteURL(); err != nil {
+		// without a remote nothing can be pushed
+		return s, nil
+	}
+	output, err := v.output(strings.Split(v.unpushed, " ")...)
+	if err != nil {
+		return s, err
+	}
+	s.Unpushed = strings.TrimSpace(output) != ""
+	return s, nil
+}
+
+// Stash sets the uncommitted changes aside, leaving a clean working
+// tree. They are kept by git stash, hg shelve or bzr shelve.
+func (v *VCS) Stash() error {
+	if v.stash == "" {
+		return fmt.Errorf("%s is not yet supported", v.name)
+	}
+	return v.config().execute(v.context(), v.Root, v.cmd, strings.Split(v.stash, " ")...)
+}
+
+// Export writes a tar archive of the tracked files at revision rev to
+// w, without version control metadata. The working tree is left
+// untouched.
+func (v *VCS) Export(rev string, w io.Writer) error {
+	var args []string
+	switch v.cmd {
+	case "git":
+		args = []string{"archive", "--format=tar", rev}
+	case "hg":
+		args = []string{"--config", "ui.archivemeta=false", "archive", "-t", "tar", "-p", ".", "-r", rev, "-"}
+	default:
+		return fmt.Errorf("%s is not yet supported", v.name)
+	}
+	cmd := exec.CommandContext(v.context(), v.cmd, args...)
+	cmd.Dir = v.Root
+	cmd.Env = v.config().Env()
+	cmd.Stdout = w
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctxErr := v.context().Err(); ctxErr != nil {
+			return ctxErr
+		}
+		msg := v.config().redact(stderr.String())
+		return &Error{Class: classify(msg), Stderr: msg, Attempts: 1}
+	}
+	return nil
+}
+
+// output runs the vcs command with args in the root of the
+// repository and returns its standard output.
+func (v *VCS) output(args ...string) (string, error) {
+	cmd := exec.CommandContext(v.context(), v.cmd, args...)
 	cmd.Dir = v.Root
 	output, err := cmd.Output()
 	if err != nil {
+		if ctxErr := v.context().Err(); ctxErr != nil {
+			return "", ctxErr
+		}
 		return "", err
 	}
-	return strings.Trim(string(output), "\n"), nil
+	return string(output), nil
 }
 
 // Checkout resets the head to hash commit for the given directory
@@ -111,7 +424,21 @@ func (v *VCS) Checkout(hash string) error {
 	}
 	args := strings.Split(v.checkout, " ")
 	args = append(args, hash)
-	return execute(v.Root, v.cmd, args...)
+	return v.config().execute(v.context(), v.Root, v.cmd, args...)
+}
+
+// HasRevision reports whether rev is available in the local
+// repository, without contacting the remote.
+func (v *VCS) HasRevision(rev string) bool {
+	if v.exists == "" {
+		return false
+	}
+	if v.cmd == "git" {
+		rev += "^{commit}"
+	}
+	args := strings.Split(v.exists, " ")
+	args = append(args, rev)
+	return v.config().execute(v.context(), v.Root, v.cmd, args...) == nil
 }
 
 // Fetch fetches all branches from remote
@@ -119,8 +446,30 @@ func (v *VCS) Fetch() error {
 	if v.fetch == "" {
 		return fmt.Errorf("%s is not yet supported", v.name)
 	}
+	if v.config().Offline {
+		return ErrOffline
+	}
+	args := strings.Split(v.fetch, " ")
+	return v.config().execute(v.context(), v.Root, v.cmd, args...)
+}
+
+// FetchFrom fetches from the repository at url instead of the
+// default remote.
+func (v *VCS) FetchFrom(url string) error {
+	if v.fetch == "" {
+		return fmt.Errorf("%s is not yet supported", v.name)
+	}
+	if v.config().Offline {
+		return ErrOffline
+	}
+	url = v.config().RewriteURL(strings.TrimPrefix(url, v.cmd+"+"))
 	args := strings.Split(v.fetch, " ")
-	return execute(v.Root, v.cmd, args...)
+	args = append(args, url)
+	if v.cmd == "git" {
+		// fetch every branch, not only the remote HEAD
+		args = append(args, "+refs/heads/*:refs/goimp/*")
+	}
+	return v.config().execute(v.context(), v.Root, v.cmd, args...)
 }
 
 // Latest gets the latest code from remote
@@ -128,38 +477,91 @@ func (v *VCS) Latest() error {
 	if v.pull == "" {
 		return fmt.Errorf("%s is not yet supported", v.name)
 	}
+	if v.config().Offline {
+		return ErrOffline
+	}
 	if v.cmd == "git" {
-		err := execute(v.Root, "git", "checkout", "master")
+		err := v.config().execute(v.context(), v.Root, "git", "checkout", "master")
 		if err != nil {
 			return err
 		}
 	}
 	args := strings.Split(v.pull, " ")
-	return execute(v.Root, v.cmd, args...)
+	return v.config().execute(v.context(), v.Root, v.cmd, args...)
 }
 
 // execute executes a command in the provided working directory
-// and returns the stderr as error.
-func execute(cwd, command string, args ...string) error {
+// and returns the stderr as an *Error. Failures of a transient class
+// are retried with exponential backoff. The command is killed when
+// ctx is done, in which case the context's error is returned.
+func (c *Config) execute(ctx context.Context, cwd, command string, args ...string) error {
+	return c.Execute(ctx, cwd, nil, nil, nil, command, args...)
+}
+
+// Execute runs a command that runs version control commands in turn,
+// such as go get, the way execute does. The variables of env are added
+// to those of Env. The standard output is copied to stdout and the
+// standard error, without credentials, to stderr when they are not
+// nil.
+func (c *Config) Execute(ctx context.Context, cwd string, env []string, stdout, stderr io.Writer, command string, args ...string) error {
+	delay := c.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err := c.run(ctx, cwd, env, stdout, stderr, command, args...)
+		verr, ok := err.(*Error)
+		if !ok || !verr.Class.Transient() || attempt > c.Retries {
+			if ok {
+				verr.Attempts = attempt
+			}
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// run executes a command once, see Execute. The command runs in a
+// process group of its own, all of which is killed when ctx is done.
+func (c *Config) run(ctx context.Context, cwd string, env []string, stdout, stderr io.Writer, command string, args ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	cmd := exec.Command(command, args...)
+	setProcessGroup(cmd)
+	cmd.Env = append(c.Env(), env...)
 	if cwd != "" {
 		cmd.Dir = cwd
 	}
-	errpipe, err := cmd.StderrPipe()
-	if err != nil {
-		return err
+	cmd.Stdout = stdout
+	var errout bytes.Buffer
+	cmd.Stderr = &errout
+	if stderr != nil {
+		w := c.Redact(stderr)
+		defer w.Close()
+		cmd.Stderr = io.MultiWriter(&errout, w)
 	}
-	err = cmd.Start()
-	if err != nil {
+	if err := cmd.Start(); err != nil {
 		return err
 	}
-	errout, err := ioutil.ReadAll(errpipe)
-	if err != nil {
-		return err
-	}
-	err = cmd.Wait()
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
 	if err != nil {
-		return fmt.Errorf("%s", errout)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		msg := c.redact(errout.String())
+		return &Error{Class: classify(msg), Stderr: msg}
 	}
 	return nil
 }
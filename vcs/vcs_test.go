@@ -0,0 +1,74 @@
+package vcs
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStatusDetachedHead(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "goimp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	git := func(repo string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	upstream := filepath.Join(dir, "upstream")
+	fork := filepath.Join(dir, "fork")
+	src := filepath.Join(dir, "src")
+	work := filepath.Join(src, "lib")
+	for _, d := range []string{upstream, src} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(upstream, "init", "-q")
+	git(upstream, "commit", "-q", "--allow-empty", "-m", "init")
+	git(dir, "clone", "-q", upstream, fork)
+	git(fork, "commit", "-q", "--allow-empty", "-m", "fork")
+	forkRev := git(fork, "rev-parse", "HEAD")
+	git(dir, "clone", "-q", upstream, work)
+	branch := git(work, "rev-parse", "--abbrev-ref", "HEAD")
+
+	v, err := New(work, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.FetchFrom(fork); err != nil {
+		t.Fatal(err)
+	}
+	git(work, "checkout", "-q", forkRev)
+	if s, err := v.Status(); err != nil || s.Unpushed {
+		t.Errorf("Status at a fork revision = %+v, %v", s, err)
+	}
+
+	git(work, "checkout", "-q", branch)
+	git(work, "checkout", "-q", "--detach")
+	git(work, "commit", "-q", "--allow-empty", "-m", "tagged")
+	git(work, "tag", "v1")
+	git(work, "checkout", "-q", branch)
+	git(work, "checkout", "-q", "v1")
+	if s, err := v.Status(); err != nil || s.Unpushed {
+		t.Errorf("Status at a tag = %+v, %v", s, err)
+	}
+
+	git(work, "checkout", "-q", branch)
+	git(work, "commit", "-q", "--allow-empty", "-m", "local")
+	if s, err := v.Status(); err != nil || !s.Unpushed {
+		t.Errorf("Status with a local commit = %+v, %v", s, err)
+	}
+}
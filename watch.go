@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satran/goimp/vcs"
+)
+
+// watcher reports changes to a set of files. Adding a directory
+// watches the .go files it contains.
+type watcher interface {
+	// Add starts watching path.
+	Add(path string) error
+	// Events returns the channel receiving the paths of changed
+	// files. A change in a watched directory may be reported with
+	// the path of the directory, and an empty path means the change
+	// could not be located.
+	Events() <-chan string
+	// Close stops watching and releases its resources.
+	Close() error
+}
+
+// pollWatcher is the portable watcher, comparing the state of
+// every watched path at a fixed interval.
+type pollWatcher struct {
+	mu     sync.Mutex
+	state  map[string]string
+	events chan string
+	done   chan struct{}
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		state:  make(map[string]string),
+		events: make(chan string, 64),
+		done:   make(chan struct{}),
+	}
+	go w.loop(interval)
+	return w
+}
+
+func (w *pollWatcher) Add(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.state[path] = fileState(path)
+	return nil
+}
+
+func (w *pollWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *pollWatcher) Close() error {
+	close(w.done)
+	return nil
+}
+
+func (w *pollWatcher) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		w.mu.Lock()
+		var changed []string
+		for path, old := range w.state {
+			if state := fileState(path); state != old {
+				w.state[path] = state
+				changed = append(changed, path)
+			}
+		}
+		w.mu.Unlock()
+		for _, path := range changed {
+			select {
+			case w.events <- path:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// fileState summarises the modification state of path, or of the .go
+// files in it when path is a directory.
+func fileState(path string) string {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if !fi.IsDir() {
+		return fmt.Sprintf("%d %d", fi.Size(), fi.ModTime().UnixNano())
+	}
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return ""
+	}
+	var state []string
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".go") {
+			continue
+		}
+		state = append(state, fmt.Sprintf("%s %d %d",
+			f.Name(), f.Size(), f.ModTime().UnixNano()))
+	}
+	return strings.Join(state, "\n")
+}
+
+// projectDirs returns dir and its subdirectories holding the
+// packages of the project, skipping hidden, vendor and testdata
+// directories.
+func projectDirs(dir string) []string {
+	var dirs []string
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		name := fi.Name()
+		if path != dir && (strings.HasPrefix(name, ".") ||
+			strings.HasPrefix(name, "_") ||
+			name == "vendor" || name == "testdata") {
+			return filepath.SkipDir
+		}
+		dirs = append(dirs, path)
+		return nil
+	})
+	return dirs
+}
+
+// watchProject watches the package directories of the project, its
+// deps file and the files recording the checked out revision of each
+// of its dependencies.
+func watchProject(w watcher, deps []Import) error {
+	for _, dir := range project.Packages {
+		for _, d := range projectDirs(dir) {
+			if err := w.Add(d); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.Add(project.DepsFile()); err != nil {
+		return err
+	}
+	for _, dep := range deps {
+		v, err := vcs.New(importDir(dep), goPathSrcs...)
+		if err != nil {
+			continue
+		}
+		if err := w.Add(v.HeadFile()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitChanges blocks until a change is reported by w and no further
+// change follows within quiet, so that a burst of saves is handled
+// once. It returns the changed paths.
+func waitChanges(w watcher, quiet time.Duration) []string {
+	changed := []string{<-w.Events()}
+	timer := time.NewTimer(quiet)
+	defer timer.Stop()
+	for {
+		select {
+		case path := <-w.Events():
+			changed = append(changed, path)
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(quiet)
+		case <-timer.C:
+			return changed
+		}
+	}
+}
@@ -0,0 +1,135 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO |
+	syscall.IN_MOVED_FROM | syscall.IN_CREATE | syscall.IN_DELETE
+
+// newWatcher returns a watcher using inotify, falling back to
+// polling at interval when inotify is not available.
+func newWatcher(interval time.Duration) (watcher, error) {
+	w, err := newInotifyWatcher()
+	if err != nil {
+		return newPollWatcher(interval), nil
+	}
+	return w, nil
+}
+
+// inotifyWatcher watches the directories of the added paths with
+// inotify, which also catches editors saving by renaming a file.
+type inotifyWatcher struct {
+	mu      sync.Mutex
+	file    *os.File
+	watches map[int32]*inotifyDir
+	events  chan string
+	done    chan struct{}
+}
+
+// inotifyDir is a watched directory. Either all its .go files or
+// the named files are reported.
+type inotifyDir struct {
+	path  string
+	all   bool
+	names *set
+}
+
+func newInotifyWatcher() (*inotifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotifyWatcher{
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: make(map[int32]*inotifyDir),
+		events:  make(chan string, 64),
+		done:    make(chan struct{}),
+	}
+	go w.loop()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Add(path string) error {
+	dir, name := path, ""
+	if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+		dir, name = filepath.Dir(path), filepath.Base(path)
+	}
+	wd, err := syscall.InotifyAddWatch(int(w.file.Fd()), dir, inotifyMask)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	d, ok := w.watches[int32(wd)]
+	if !ok {
+		d = &inotifyDir{path: dir, names: newSet()}
+		w.watches[int32(wd)] = d
+	}
+	if name == "" {
+		d.all = true
+	} else {
+		d.names.Add(name)
+	}
+	return nil
+}
+
+func (w *inotifyWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	close(w.done)
+	return w.file.Close()
+}
+
+func (w *inotifyWatcher) loop() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			path, ok := w.match(ev, strings.TrimRight(string(name), "\x00"))
+			if !ok {
+				continue
+			}
+			select {
+			case w.events <- path:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// match returns the path reported by ev and whether it is watched.
+func (w *inotifyWatcher) match(ev *syscall.InotifyEvent, name string) (string, bool) {
+	if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		return "", true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	d, ok := w.watches[ev.Wd]
+	if !ok {
+		return "", false
+	}
+	if d.names.Contains(name) || (d.all && strings.HasSuffix(name, ".go")) {
+		return filepath.Join(d.path, name), true
+	}
+	return "", false
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "time"
+
+// newWatcher returns the watcher for the platform, which polls
+// for changes at interval.
+func newWatcher(interval time.Duration) (watcher, error) {
+	return newPollWatcher(interval), nil
+}
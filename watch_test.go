@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	w, err := newWatcher(10 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWatcher(t, w)
+}
+
+func TestPollWatcher(t *testing.T) {
+	testWatcher(t, newPollWatcher(10*time.Millisecond))
+}
+
+func testWatcher(t *testing.T, w watcher) {
+	defer w.Close()
+	dir, err := ioutil.TempDir("", "goimp-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	deps := filepath.Join(dir, "Godeps")
+	if err := ioutil.WriteFile(deps, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(deps); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"main.go", "Godeps"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan []string)
+		go func() { done <- waitChanges(w, 50*time.Millisecond) }()
+		select {
+		case changes := <-done:
+			for _, got := range changes {
+				if got != path && got != dir {
+					t.Fatalf("got change of %q, want %q", got, path)
+				}
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no change reported for %q", path)
+		}
+	}
+}
@@ -2,48 +2,154 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"text/tabwriter"
+
+	"github.com/satran/goimp/deps"
 )
 
 var cmdWrite = &Command{
-	UsageLine: "write [-r] [-p] [-hash] [-file]",
+	UsageLine: "write [-r] [-p] [-hash] [-force] [-file] [-tags]",
 	Short:     "writes imports of the package",
 	Long: `writes imports of the package
 
+An existing file is merged with the imports: entries still imported
+keep their pinned revision, entries no longer imported are removed and
+only new dependencies are resolved from the workspace. The changes are
+printed before the file is written.
+
+The file is replaced atomically once complete, so a failure leaves it
+unchanged, while a lock under .goimp keeps concurrent runs of write,
+tidy and bind from interleaving.
+
 -r	writes imports recursively, do note that the dependent 
-	repositories should exist
+	repositories should exist. Without it only the direct imports are
+	walked, so no entry of an existing file is removed
 -p	specify the directory of the package, by default it is "."
--hash	prints out the commit hash of each repository
+	or the packages of the project configuration
+-hash	resolves the commit hash of new dependencies from the workspace,
+	they are written without one otherwise. Entries of an existing
+	file keep their pin either way, unless -force is given which
+	applies it to every dependency
+-force	writes the revisions checked out in the workspace for every
+	dependency, replacing the existing pins
 -file	file to write to, defaults to Godeps
+-tags	comma separated build tags used to select the files parsed
+
+A warning is printed while packages are linked with 'goimp link'.
 `,
 }
 
 func init() {
 	cmdWrite.Run = runWrite // break init loop
+	addProjectFlags(&cmdWrite.Flag, "p", "file", "tags")
 }
 
 var (
-	writeDir       = cmdWrite.Flag.String("p", ".", "path of the go package")
-	writeFile      = cmdWrite.Flag.String("file", "Godeps", "file to write to")
 	writeRecursive = cmdWrite.Flag.Bool("r", true, "recursively write imports")
 	writeHash      = cmdWrite.Flag.Bool("hash", true, "print out the commit hash")
+	writeForce     = cmdWrite.Flag.Bool("force", false, "write the workspace revisions")
 )
 
 func runWrite(cmd *Command, args []string) {
-	file, err := os.OpenFile(
-		filepath.Join(*writeDir, *writeFile),
-		os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	links, err := readLinks()
 	if err != nil {
-		elog.Println(err)
+		elog.Fatal(err)
+	}
+	if len(links) > 0 {
+		elog.Print("warning: links are active, the revisions of these local directories are written:")
+		for _, pkg := range sortedKeys(links) {
+			elog.Printf("\t%s -> %s", pkg, links[pkg])
+		}
 	}
-	defer file.Close()
 
-	w := new(tabwriter.Writer)
-	w.Init(file, 0, 8, 0, '\t', 0)
-	for _, imp := range list(*listDir, *listRecursive, *listHash) {
-		fmt.Fprintf(w, "%s\t%s\n", imp.Package, imp.Hash)
+	unlock, err := lockDeps()
+	if err != nil {
+		elog.Fatal(err)
+	}
+	defer unlock()
+
+	path := project.DepsFile()
+	var entries, imports []Import
+	if exists(path) {
+		entries = getImportsFromFile(path)
+	}
+	if *writeForce || entries == nil {
+		imports = list(project.Packages, *writeRecursive, *writeHash)
+	} else {
+		unused, missing := tidyDiff(entries, walkImports(project.Packages, *writeRecursive))
+		if !*writeRecursive {
+			// entries for dependencies of dependencies were not walked
+			unused = nil
+		}
+		imports = tidyImports(entries, unused, missing, *writeHash)
+	}
+	if printChanges(os.Stdout, importsToMap(entries), importsToMap(imports)) == 0 && exists(path) {
+		return
+	}
+	if err := deps.WriteFile(path, imports); err != nil {
+		elog.Fatal(err)
+	}
+}
+
+// printChanges prints the changes from the deps file entries before
+// to after to w, one per line prefixed with + for an added entry, - for a
+// removed one and ~ for a new revision. It returns the number of
+// changes.
+func printChanges(w io.Writer, before, after map[string]string) int {
+	changes := diffImports(before, after)
+	tw := new(tabwriter.Writer)
+	tw.Init(w, 0, 8, 1, ' ', 0)
+	for _, c := range changes {
+		_, had := before[c.Package]
+		_, has := after[c.Package]
+		switch {
+		case !had:
+			fmt.Fprintf(tw, "+\t%s\t%s\n", c.Package, c.New)
+		case !has:
+			fmt.Fprintf(tw, "-\t%s\t%s\n", c.Package, c.Old)
+		default:
+			fmt.Fprintf(tw, "~\t%s\t%s -> %s\n", c.Package, c.Old, c.New)
+		}
+	}
+	tw.Flush()
+	return len(changes)
+}
+
+// writeDeps writes the imports of the project to path and returns them.
+// The imports found in keep are written with the revision it holds
+// rather than the one of the workspace.
+func writeDeps(path string, recursive, hash bool, keep map[string]string) ([]Import, error) {
+	unlock, err := lockDeps()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	imports := keepPins(list(project.Packages, recursive, hash), keep)
+	if err := deps.WriteFile(path, imports); err != nil {
+		return nil, err
+	}
+	return imports, nil
+}
+
+// lockDeps takes the advisory lock guarding the read-modify-write of
+// the deps file of the project and returns the function releasing it.
+func lockDeps() (func(), error) {
+	if err := os.MkdirAll(project.StateDir(), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(project.StateDir(), "lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error locking deps file: %s", err)
 	}
-	w.Flush()
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
 }